@@ -0,0 +1,143 @@
+package block_stm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+)
+
+type pathConflicts struct {
+	aborts             int
+	validationFailures int
+	incarnations       int
+}
+
+// conflictCounts: updated by the executors as transactions abort or fail validation because of a path
+type conflictCounts struct {
+	mu    sync.Mutex
+	paths map[string]*pathConflicts
+}
+
+func makeConflictCounts() *conflictCounts {
+	return &conflictCounts{paths: make(map[string]*pathConflicts)}
+}
+
+func (c *conflictCounts) add(path []byte, aborts, validationFailures, incarnations int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	pc, ok := c.paths[string(path)]
+	if !ok {
+		pc = &pathConflicts{}
+		c.paths[string(path)] = pc
+	}
+	pc.aborts += aborts
+	pc.validationFailures += validationFailures
+	pc.incarnations += incarnations
+}
+
+// PathConflicts: how much a path was contended in a block
+type PathConflicts struct {
+	Path []byte `json:"path"`
+	// Writers is the number of transactions whose final write set includes the path
+	Writers int `json:"writers"`
+	// Aborts is the number of executions aborted on reading an estimate of the path
+	Aborts int `json:"aborts"`
+	// ValidationFailures is the number of validations that failed on a read of the path
+	ValidationFailures int `json:"validationFailures"`
+	// Incarnations is the number of incarnations caused by the aborts and validation failures
+	Incarnations int `json:"incarnations"`
+}
+
+// ConflictReport: the paths of a block ranked by the incarnations they caused, to find the hot keys that serialize
+//  execution
+type ConflictReport struct {
+	NumTxns int `json:"numTxns"`
+	// ExtraIncarnations is the number of incarnations beyond the first of every transaction
+	ExtraIncarnations int             `json:"extraIncarnations"`
+	Paths             []PathConflicts `json:"paths"`
+}
+
+// BuildConflictReport: the report from the outcome of ExecuteParallel or ExecuteScheduled. Paths that were written
+//  by a transaction or involved in a conflict are included, ranked by incarnations, validation failures, aborts and
+//  then writers.
+func BuildConflictReport(txIO *TxnInputOutput, stats ExecStats) *ConflictReport {
+	report := &ConflictReport{NumTxns: stats.NumTxns}
+	for _, inc := range stats.Incarnations {
+		report.ExtraIncarnations += inc - 1
+	}
+
+	paths := make(map[string]*PathConflicts)
+	getPath := func(path string) *PathConflicts {
+		pc, ok := paths[path]
+		if !ok {
+			pc = &PathConflicts{Path: []byte(path)}
+			paths[path] = pc
+		}
+		return pc
+	}
+
+	txIO.rw.RLock()
+	for _, output := range txIO.outputs {
+		for _, wd := range output {
+			getPath(string(wd.Path)).Writers++
+		}
+	}
+	txIO.rw.RUnlock()
+
+	if stats.conflicts != nil {
+		stats.conflicts.mu.Lock()
+		for path, c := range stats.conflicts.paths {
+			pc := getPath(path)
+			pc.Aborts, pc.ValidationFailures, pc.Incarnations = c.aborts, c.validationFailures, c.incarnations
+		}
+		stats.conflicts.mu.Unlock()
+	}
+
+	report.Paths = make([]PathConflicts, 0, len(paths))
+	for _, pc := range paths {
+		report.Paths = append(report.Paths, *pc)
+	}
+	sort.Slice(report.Paths, func(i, j int) bool {
+		pi, pj := report.Paths[i], report.Paths[j]
+		switch {
+		case pi.Incarnations != pj.Incarnations:
+			return pi.Incarnations > pj.Incarnations
+		case pi.ValidationFailures != pj.ValidationFailures:
+			return pi.ValidationFailures > pj.ValidationFailures
+		case pi.Aborts != pj.Aborts:
+			return pi.Aborts > pj.Aborts
+		case pi.Writers != pj.Writers:
+			return pi.Writers > pj.Writers
+		}
+		return string(pi.Path) < string(pj.Path)
+	})
+	return report
+}
+
+// WriteJSON: the report as JSON. Paths are base64 encoded.
+func (r *ConflictReport) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(r)
+}
+
+// WriteText: the report as a table of at most max paths, or all of them if max is zero
+func (r *ConflictReport) WriteText(w io.Writer, max int) error {
+	paths := r.Paths
+	if max > 0 && len(paths) > max {
+		paths = paths[:max]
+	}
+	if _, err := fmt.Fprintf(w, "%v txns, %v extra incarnations, %v paths\n", r.NumTxns, r.ExtraIncarnations,
+		len(r.Paths)); err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "path\twriters\taborts\tvalidation failures\tincarnations\t")
+	for _, pc := range paths {
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t\n", strconv.Quote(string(pc.Path)), pc.Writers, pc.Aborts,
+			pc.ValidationFailures, pc.Incarnations)
+	}
+	return tw.Flush()
+}
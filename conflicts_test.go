@@ -0,0 +1,75 @@
+package block_stm
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestConflictReport(t *testing.T) {
+	var rw testBaseReadWrite
+
+	// all conflicting txs write the one path, which causes every incarnation beyond the first
+	e := NewExecutor(ExecOptions{})
+	for _, execute := range testExecutors(e) {
+		txIO, _, stats, err := execute(makeTestConflictTasks(20), &rw)
+		require.NoError(t, err)
+		report := BuildConflictReport(txIO, stats)
+		require.Equal(t, 20, report.NumTxns)
+		require.Len(t, report.Paths, 1)
+		pc := report.Paths[0]
+		require.Equal(t, []byte("test-key-0"), pc.Path)
+		require.Equal(t, 20, pc.Writers)
+		require.Equal(t, int(stats.Aborts), pc.Aborts)
+		require.Equal(t, int(stats.ValidationFailures), pc.ValidationFailures)
+		require.Equal(t, report.ExtraIncarnations, pc.Incarnations)
+	}
+
+	// with random programs the incarnations are spread over the paths but still add up. programs can fail so failures
+	//  are recorded rather than failing the block
+	e = NewExecutor(ExecOptions{ErrorPolicy: ErrorPolicyRecordFailure})
+	for seed := int64(0); seed < 5; seed++ {
+		exec := makeTestPrograms(seed, 50, 4, 8)
+		for _, execute := range testExecutors(e) {
+			txIO, _, stats, err := execute(exec, &rw)
+			require.NoError(t, err)
+			report := BuildConflictReport(txIO, stats)
+			require.NotEmpty(t, report.Paths)
+			var sumIncarnations, sumValidationFailures int
+			for i, pc := range report.Paths {
+				sumIncarnations += pc.Incarnations
+				sumValidationFailures += pc.ValidationFailures
+				if i > 0 {
+					require.LessOrEqual(t, pc.Incarnations, report.Paths[i-1].Incarnations)
+				}
+			}
+			require.Equal(t, report.ExtraIncarnations, sumIncarnations)
+			require.Equal(t, int(stats.ValidationFailures), sumValidationFailures)
+		}
+	}
+}
+
+func TestConflictReportOutput(t *testing.T) {
+	report := &ConflictReport{NumTxns: 10, ExtraIncarnations: 4, Paths: []PathConflicts{
+		{Path: []byte("hot"), Writers: 8, Aborts: 2, ValidationFailures: 2, Incarnations: 4},
+		{Path: []byte("warm"), Writers: 2},
+		{Path: []byte("cold"), Writers: 1},
+	}}
+
+	var buf bytes.Buffer
+	require.NoError(t, report.WriteText(&buf, 2))
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	require.Len(t, lines, 4)
+	require.Equal(t, "10 txns, 4 extra incarnations, 3 paths", lines[0])
+	require.Equal(t, []string{"path", "writers", "aborts", "validation", "failures", "incarnations"}, strings.Fields(lines[1]))
+	require.Equal(t, []string{`"hot"`, "8", "2", "2", "4"}, strings.Fields(lines[2]))
+	require.Equal(t, []string{`"warm"`, "2", "0", "0", "0"}, strings.Fields(lines[3]))
+
+	buf.Reset()
+	require.NoError(t, report.WriteJSON(&buf))
+	var decoded ConflictReport
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	require.Equal(t, *report, decoded)
+}
@@ -0,0 +1,199 @@
+package block_stm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DepEdge: transaction To read the writes of transaction From to the paths
+type DepEdge struct {
+	From  int      `json:"from"`
+	To    int      `json:"to"`
+	Paths [][]byte `json:"paths"`
+}
+
+// DepGraph: the read-from dependencies between the transactions of an executed block. Transactions only ever read
+//  from lower transactions so this is a DAG, and any schedule has to execute a transaction after its dependencies -
+//  the critical path is the longest chain of them.
+type DepGraph struct {
+	NumTxns int
+	// Edges are ordered by To and then From
+	Edges []DepEdge
+
+	deps  [][]int
+	depth []int
+	// prev is the dependency on the longest chain to each transaction, -1 if there is none
+	prev []int
+}
+
+// BuildDepGraph: the dependencies from the final read sets of a completed execution. Reads from storage have no
+//  dependency.
+func BuildDepGraph(txIO *TxnInputOutput) *DepGraph {
+	txIO.rw.RLock()
+	defer txIO.rw.RUnlock()
+
+	g := &DepGraph{
+		NumTxns: len(txIO.inputs),
+		deps:    make([][]int, len(txIO.inputs)),
+		depth:   make([]int, len(txIO.inputs)),
+		prev:    make([]int, len(txIO.inputs)),
+	}
+	for to, input := range txIO.inputs {
+		paths := make(map[int][][]byte)
+		for _, rd := range input {
+			if rd.Kind == ReadKindMap && rd.V.TxnIndex >= 0 {
+				paths[rd.V.TxnIndex] = append(paths[rd.V.TxnIndex], rd.Path)
+			}
+		}
+		for from, p := range paths {
+			sort.Slice(p, func(i, j int) bool { return string(p[i]) < string(p[j]) })
+			g.Edges = append(g.Edges, DepEdge{From: from, To: to, Paths: p})
+			g.deps[to] = append(g.deps[to], from)
+		}
+		sort.Ints(g.deps[to])
+	}
+	sort.Slice(g.Edges, func(i, j int) bool {
+		if g.Edges[i].To != g.Edges[j].To {
+			return g.Edges[i].To < g.Edges[j].To
+		}
+		return g.Edges[i].From < g.Edges[j].From
+	})
+
+	// dependencies are always lower so depths can be worked out in transaction order
+	for tx := range g.depth {
+		g.depth[tx], g.prev[tx] = 1, -1
+		for _, dep := range g.deps[tx] {
+			if g.depth[dep]+1 > g.depth[tx] {
+				g.depth[tx], g.prev[tx] = g.depth[dep]+1, dep
+			}
+		}
+	}
+	return g
+}
+
+// Dependencies: the transactions txIdx read from, in order
+func (g *DepGraph) Dependencies(txIdx int) []int {
+	return g.deps[txIdx]
+}
+
+// Depth: the length of the longest chain of dependencies ending with txIdx, including itself
+func (g *DepGraph) Depth(txIdx int) int {
+	return g.depth[txIdx]
+}
+
+// CriticalPath: the longest chain of dependencies, in transaction order. The lowest one if there are several.
+func (g *DepGraph) CriticalPath() (path []int) {
+	last := -1
+	for tx, d := range g.depth {
+		if last == -1 || d > g.depth[last] {
+			last = tx
+		}
+	}
+	for tx := last; tx != -1; tx = g.prev[tx] {
+		path = append(path, tx)
+	}
+	for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+		path[i], path[j] = path[j], path[i]
+	}
+	return
+}
+
+// CriticalPathLength: the number of transactions on the critical path - the fewest rounds of execution the block
+//  could be done in with unlimited workers
+func (g *DepGraph) CriticalPathLength() (length int) {
+	for _, d := range g.depth {
+		if d > length {
+			length = d
+		}
+	}
+	return
+}
+
+// MaxParallelism: the most transactions that can execute at once when each executes as soon as its dependencies
+//  are done
+func (g *DepGraph) MaxParallelism() (max int) {
+	width := make(map[int]int)
+	for _, d := range g.depth {
+		width[d]++
+		if width[d] > max {
+			max = width[d]
+		}
+	}
+	return
+}
+
+// AverageParallelism: transactions over the critical path length, the bound on the speedup over serial execution
+//  if all transactions took the same time
+func (g *DepGraph) AverageParallelism() float64 {
+	if g.NumTxns == 0 {
+		return 0
+	}
+	return float64(g.NumTxns) / float64(g.CriticalPathLength())
+}
+
+// WriteDOT: the graph in Graphviz DOT format with the critical path highlighted and edges labeled with the paths
+func (g *DepGraph) WriteDOT(w io.Writer) error {
+	critical := make(map[int]bool)
+	path := g.CriticalPath()
+	for _, tx := range path {
+		critical[tx] = true
+	}
+	onPath := func(e DepEdge) bool {
+		return critical[e.To] && g.prev[e.To] == e.From
+	}
+
+	var sb strings.Builder
+	sb.WriteString("digraph txdeps {\n\trankdir=LR;\n\tnode [shape=box];\n")
+	for tx := 0; tx < g.NumTxns; tx++ {
+		attrs := ""
+		if critical[tx] {
+			attrs = ", color=red, penwidth=2"
+		}
+		fmt.Fprintf(&sb, "\ttx%v [label=\"tx %v\"%v];\n", tx, tx, attrs)
+	}
+	for _, e := range g.Edges {
+		labels := make([]string, len(e.Paths))
+		for i, p := range e.Paths {
+			labels[i] = strconv.Quote(string(p))
+		}
+		attrs := ""
+		if onPath(e) {
+			attrs = ", color=red, penwidth=2"
+		}
+		fmt.Fprintf(&sb, "\ttx%v -> tx%v [label=%v%v];\n", e.From, e.To, strconv.Quote(strings.Join(labels, "\n")), attrs)
+	}
+	sb.WriteString("}\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+type depGraphJSON struct {
+	NumTxns            int       `json:"numTxns"`
+	CriticalPath       []int     `json:"criticalPath"`
+	CriticalPathLength int       `json:"criticalPathLength"`
+	MaxParallelism     int       `json:"maxParallelism"`
+	AverageParallelism float64   `json:"averageParallelism"`
+	Depths             []int     `json:"depths"`
+	Edges              []DepEdge `json:"edges"`
+}
+
+// WriteJSON: the graph and its summary as JSON. Paths are base64 encoded.
+func (g *DepGraph) WriteJSON(w io.Writer) error {
+	edges := g.Edges
+	if edges == nil {
+		edges = []DepEdge{}
+	}
+	return json.NewEncoder(w).Encode(depGraphJSON{
+		NumTxns:            g.NumTxns,
+		CriticalPath:       g.CriticalPath(),
+		CriticalPathLength: g.CriticalPathLength(),
+		MaxParallelism:     g.MaxParallelism(),
+		AverageParallelism: g.AverageParallelism(),
+		Depths:             g.depth,
+		Edges:              edges,
+	})
+}
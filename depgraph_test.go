@@ -0,0 +1,92 @@
+package block_stm
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func makeTestRead(path string, txIdx int) ReadDescriptor {
+	if txIdx < 0 {
+		return ReadDescriptor{Path: []byte(path), Kind: ReadKindStorage, V: Version{-1, -1}}
+	}
+	return ReadDescriptor{Path: []byte(path), Kind: ReadKindMap, V: Version{TxnIndex: txIdx}}
+}
+
+func TestDepGraph(t *testing.T) {
+	// 0 <- 1 <- 2 <- 4, 0 <- 4 and 3 only reads storage
+	txIO := MakeTxnInputOutput(5)
+	txIO.recordRead(0, []ReadDescriptor{makeTestRead("a", -1)})
+	txIO.recordRead(1, []ReadDescriptor{makeTestRead("a", 0), makeTestRead("b", -1)})
+	txIO.recordRead(2, []ReadDescriptor{makeTestRead("b", 1)})
+	txIO.recordRead(3, []ReadDescriptor{makeTestRead("c", -1)})
+	txIO.recordRead(4, []ReadDescriptor{makeTestRead("z", 2), makeTestRead("y", 0), makeTestRead("x", 0)})
+
+	g := BuildDepGraph(txIO)
+	require.Equal(t, 5, g.NumTxns)
+	require.Equal(t, []DepEdge{
+		{From: 0, To: 1, Paths: [][]byte{[]byte("a")}},
+		{From: 1, To: 2, Paths: [][]byte{[]byte("b")}},
+		{From: 0, To: 4, Paths: [][]byte{[]byte("x"), []byte("y")}},
+		{From: 2, To: 4, Paths: [][]byte{[]byte("z")}},
+	}, g.Edges)
+	require.Equal(t, []int{0, 2}, g.Dependencies(4))
+	require.Empty(t, g.Dependencies(3))
+	require.Equal(t, 4, g.Depth(4))
+	require.Equal(t, 1, g.Depth(3))
+
+	require.Equal(t, []int{0, 1, 2, 4}, g.CriticalPath())
+	require.Equal(t, 4, g.CriticalPathLength())
+	require.Equal(t, 2, g.MaxParallelism())
+	require.Equal(t, 1.25, g.AverageParallelism())
+
+	var dot bytes.Buffer
+	require.NoError(t, g.WriteDOT(&dot))
+	require.Contains(t, dot.String(), "digraph txdeps {\n")
+	require.Contains(t, dot.String(), "\ttx3 [label=\"tx 3\"];\n")
+	require.Contains(t, dot.String(), "\ttx4 [label=\"tx 4\", color=red, penwidth=2];\n")
+	require.Contains(t, dot.String(), "\ttx0 -> tx4 [label=\"\\\"x\\\"\\n\\\"y\\\"\"];\n")
+	require.Contains(t, dot.String(), "\ttx2 -> tx4 [label=\"\\\"z\\\"\", color=red, penwidth=2];\n")
+
+	var buf bytes.Buffer
+	require.NoError(t, g.WriteJSON(&buf))
+	var decoded struct {
+		NumTxns            int       `json:"numTxns"`
+		CriticalPath       []int     `json:"criticalPath"`
+		MaxParallelism     int       `json:"maxParallelism"`
+		AverageParallelism float64   `json:"averageParallelism"`
+		Depths             []int     `json:"depths"`
+		Edges              []DepEdge `json:"edges"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+	require.Equal(t, 5, decoded.NumTxns)
+	require.Equal(t, []int{0, 1, 2, 4}, decoded.CriticalPath)
+	require.Equal(t, 2, decoded.MaxParallelism)
+	require.Equal(t, []int{1, 2, 3, 1, 4}, decoded.Depths)
+	require.Equal(t, g.Edges, decoded.Edges)
+}
+
+func TestDepGraphExecution(t *testing.T) {
+	var rw testBaseReadWrite
+
+	// every conflicting tx reads from the one before so the block is a single chain ...
+	txIO, _, _, err := ExecuteParallel(makeTestConflictTasks(20), &rw)
+	require.NoError(t, err)
+	g := BuildDepGraph(txIO)
+	require.Equal(t, 20, g.CriticalPathLength())
+	require.Equal(t, 1, g.MaxParallelism())
+	require.Len(t, g.Edges, 19)
+
+	// ... while independent txs have no dependencies at all
+	var exec []ExecTask
+	for i := 0; i < 20; i++ {
+		exec = append(exec, testIndependentExecTask{testExecTask{num: i}})
+	}
+	txIO, _, _, err = ExecuteParallel(exec, &rw)
+	require.NoError(t, err)
+	g = BuildDepGraph(txIO)
+	require.Equal(t, 1, g.CriticalPathLength())
+	require.Equal(t, 20, g.MaxParallelism())
+	require.Empty(t, g.Edges)
+}
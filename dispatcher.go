@@ -0,0 +1,87 @@
+package block_stm
+
+import (
+	"context"
+	"sync"
+)
+
+// dispatcher: how the ExecuteParallel coordinator hands execution tasks to workers and gets their results back. The
+//  coordinator only ever has as many tasks dispatched as there are workers.
+type dispatcher interface {
+	// dispatch: queues a task for execution, never blocks
+	dispatch(task ExecVersionView)
+	// next: blocks until the next result is delivered. Returns false if ctx is done first.
+	next(ctx context.Context) (ExecResult, bool)
+	// validationBatch: the number of pending validations to do before waiting for the next result, while there are
+	//  still tasks to execute
+	validationBatch(max int) int
+	// stop: drops any queued tasks and stops the workers once they finish their current task, waiting for them
+	//  unless abandon is set
+	stop(abandon bool)
+}
+
+// workerDispatcher: the default dispatcher with a pool of worker goroutines
+type workerDispatcher struct {
+	chTasks   chan ExecVersionView
+	chResults chan ExecResult
+	chDone    chan bool
+	wg        sync.WaitGroup
+}
+
+func startWorkerDispatcher(numWorkers, numTasks int, logger Logger) *workerDispatcher {
+	d := &workerDispatcher{
+		chTasks:   make(chan ExecVersionView, numTasks),
+		chResults: make(chan ExecResult, numTasks),
+		chDone:    make(chan bool),
+	}
+	for i := 0; i < numWorkers; i++ {
+		d.wg.Add(1)
+		go func(procNum int) {
+			defer d.wg.Done()
+		Loop:
+			for {
+				select {
+				case task := <-d.chTasks:
+					{
+						res := task.Execute()
+						res.worker = procNum
+						d.chResults <- res
+					}
+				case <-d.chDone:
+					break Loop
+				}
+			}
+			if logger.Enabled(LogLevelDebug) {
+				logger.Log(LogLevelDebug, "worker done", F("worker", procNum))
+			}
+		}(i)
+	}
+	return d
+}
+
+func (d *workerDispatcher) dispatch(task ExecVersionView) {
+	d.chTasks <- task
+}
+
+func (d *workerDispatcher) next(ctx context.Context) (res ExecResult, ok bool) {
+	select {
+	case res = <-d.chResults:
+		return res, true
+	case <-ctx.Done():
+		return res, false
+	}
+}
+
+func (d *workerDispatcher) validationBatch(max int) int {
+	return max
+}
+
+func (d *workerDispatcher) stop(abandon bool) {
+	for len(d.chTasks) > 0 {
+		<-d.chTasks
+	}
+	close(d.chDone)
+	if !abandon {
+		d.wg.Wait()
+	}
+}
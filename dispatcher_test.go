@@ -0,0 +1,128 @@
+package block_stm
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"math/rand"
+	"testing"
+)
+
+// seededDispatcher: executes tasks on the coordinator goroutine with every choice of interleaving made by a seeded
+//  random source, so the same seed always replays the same schedule. Each task is executed at a random point between
+//  being dispatched and its result being delivered, and results are delivered in random order.
+type seededDispatcher struct {
+	r       *rand.Rand
+	queued  []ExecVersionView
+	results []ExecResult
+}
+
+// withSeededSchedule: replaces the worker goroutines of e.ExecuteParallel with a seededDispatcher
+func withSeededSchedule(e *Executor, seed int64) *Executor {
+	e.makeDispatcher = func(int) dispatcher {
+		return &seededDispatcher{r: rand.New(rand.NewSource(seed))}
+	}
+	return e
+}
+
+func (d *seededDispatcher) dispatch(task ExecVersionView) {
+	d.queued = append(d.queued, task)
+}
+
+func (d *seededDispatcher) next(ctx context.Context) (ExecResult, bool) {
+	for {
+		if ctx.Err() != nil {
+			return ExecResult{}, false
+		}
+		// either deliver one of the results or execute one of the queued tasks
+		if len(d.results) > 0 && (len(d.queued) == 0 || d.r.Intn(2) == 0) {
+			i := d.r.Intn(len(d.results))
+			res := d.results[i]
+			d.results = append(d.results[:i], d.results[i+1:]...)
+			return res, true
+		}
+		if len(d.queued) == 0 {
+			panic("should not happen - waiting for a result with nothing dispatched")
+		}
+		i := d.r.Intn(len(d.queued))
+		task := d.queued[i]
+		d.queued = append(d.queued[:i], d.queued[i+1:]...)
+		d.results = append(d.results, task.Execute())
+	}
+}
+
+func (d *seededDispatcher) validationBatch(max int) int {
+	return d.r.Intn(max + 1)
+}
+
+func (d *seededDispatcher) stop(_ bool) {
+	d.queued, d.results = nil, nil
+}
+
+// recordSchedule: the sequence of execution outcomes of a seeded schedule
+func recordSchedule(t *testing.T, exec []ExecTask, opts ExecOptions, seed int64) (events []ExecEvent) {
+	opts.Hooks.OnExecute = func(ev ExecEvent) {
+		events = append(events, ev)
+	}
+	_, _, _, err := withSeededSchedule(NewExecutor(opts), seed).ExecuteParallel(exec, testBaseReadWrite{})
+	require.NoError(t, err)
+	return
+}
+
+func TestSeededScheduleReplay(t *testing.T) {
+	exec := makeTestPrograms(1, 50, 4, 8)
+	opts := ExecOptions{ErrorPolicy: ErrorPolicyRecordFailure, Workers: 8}
+
+	schedules := make(map[string]bool)
+	for seed := int64(0); seed < 5; seed++ {
+		events := recordSchedule(t, exec, opts, seed)
+		require.Equal(t, events, recordSchedule(t, exec, opts, seed), "seed %v", seed)
+		schedules[fmt.Sprint(events)] = true
+	}
+	require.Greater(t, len(schedules), 1, "different seeds give different schedules")
+}
+
+// checkSeededAgainstSerial: as checkAgainstSerial but with the interleaving of ExecuteParallel decided by scheduleSeed
+func checkSeededAgainstSerial(t *testing.T, exec []ExecTask, scheduleSeed int64) {
+	var rw testBaseReadWrite
+	for _, opts := range []ExecOptions{
+		{Workers: 4},
+		{Workers: 4, BufferWrites: true, ErrorPolicy: ErrorPolicyRecordFailure},
+	} {
+		e := withSeededSchedule(NewExecutor(opts), scheduleSeed)
+		serialTxIO, serialDiff, _, serialErr := e.ExecuteSerial(exec, &rw)
+		txIO, diff, _, err := e.ExecuteParallel(exec, &rw)
+		if serialErr != nil {
+			var serialTxErr, txErr *TxnError
+			require.ErrorAs(t, serialErr, &serialTxErr)
+			require.ErrorAs(t, err, &txErr, "schedule %v, options %+v", scheduleSeed, opts)
+			require.Equal(t, serialTxErr.Version.TxnIndex, txErr.Version.TxnIndex, "schedule %v, options %+v", scheduleSeed, opts)
+			continue
+		}
+		require.NoError(t, err, "schedule %v, options %+v", scheduleSeed, opts)
+		require.Empty(t, Compare(serialDiff, diff), "schedule %v, options %+v", scheduleSeed, opts)
+		for i := range exec {
+			require.Equal(t, serialTxIO.Err(i), txIO.Err(i), "tx %v, schedule %v, options %+v", i, scheduleSeed, opts)
+		}
+	}
+}
+
+func TestSeededSchedules(t *testing.T) {
+	for seed := int64(0); seed < 50; seed++ {
+		checkSeededAgainstSerial(t, makeTestPrograms(seed, 30, 1+int(seed%6), 8), seed)
+	}
+}
+
+// go test -run XXX -fuzz FuzzSeededSchedule
+//  a failure found here replays exactly from its inputs so can be added as a regression test with f.Add
+func FuzzSeededSchedule(f *testing.F) {
+	f.Add(int64(1), int64(1), uint8(20), uint8(2))
+	f.Add(int64(2), int64(7), uint8(50), uint8(8))
+	f.Add(int64(3), int64(3), uint8(0), uint8(4))
+	f.Fuzz(func(t *testing.T, seed, scheduleSeed int64, numTxns, numKeys uint8) {
+		if numKeys == 0 {
+			t.Skip()
+		}
+		checkSeededAgainstSerial(t, makeTestPrograms(seed, int(numTxns), int(numKeys), 8), scheduleSeed)
+	})
+}
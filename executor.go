@@ -1,11 +1,16 @@
 package block_stm
 
 import (
-	"encoding/base64"
+	"context"
+	"errors"
 	"fmt"
+	"runtime/debug"
+	"time"
 )
 
-func validateVersion(txIdx int, lastInputOutput *TxnInputOutput, versionedData *MVHashMap) (valid bool) {
+// validateVersion: returns false, with the path of the first read that is no longer valid, if the transaction has to
+//  be executed again
+func validateVersion(txIdx int, lastInputOutput *TxnInputOutput, versionedData *MVHashMap) (valid bool, invalidPath []byte) {
 
 	valid = true
 	for _, rd := range lastInputOutput.readSet(txIdx) {
@@ -24,6 +29,7 @@ func validateVersion(txIdx int, lastInputOutput *TxnInputOutput, versionedData *
 			panic(fmt.Errorf("should not happen - undefined mv read status: %ver", mvResult.status()))
 		}
 		if !valid {
+			invalidPath = rd.Path
 			break
 		}
 	}
@@ -31,11 +37,98 @@ func validateVersion(txIdx int, lastInputOutput *TxnInputOutput, versionedData *
 	return
 }
 
+// recordTxnOutput: records the reads and writes of a successful incarnation. Locations written by the previous
+//  incarnation but not by this one are removed from the MVHashMap. Returns true if this incarnation wrote to a location
+//  the previous one did not.
+func recordTxnOutput(res ExecResult, txIO *TxnInputOutput, mvh *MVHashMap) (wroteNewPath bool, err error) {
+	prevOut := TxnOutput(txIO.writeSet(res.ver.TxnIndex))
+	wroteNewPath = res.txOut.hasNewWrite(prevOut)
+	for _, v := range prevOut.pathsNotIn(res.txOut) {
+		if err = mvh.Delete(v.Path, res.ver.TxnIndex); err != nil {
+			return
+		}
+	}
+	txIO.recordRead(res.ver.TxnIndex, res.txIn)
+	txIO.recordWrite(res.ver.TxnIndex, res.txOut)
+	txIO.recordErr(res.ver.TxnIndex, res.err)
+	return
+}
+
+// recordTxnFailure: a failed incarnation is recorded as having made its reads but no writes, so it is validated and
+//  executed again if what it read changes. Any partial writes and the writes of the previous incarnation are removed.
+//  Returns true if there were any partial writes since higher transactions may have read them, the same as a write to
+//  a new location.
+func recordTxnFailure(res ExecResult, txIO *TxnInputOutput, mvh *MVHashMap) (wroteNewPath bool, err error) {
+	for _, v := range res.txOut.pathsNotIn(txIO.writeSet(res.ver.TxnIndex)) {
+		if err = mvh.Delete(v.Path, res.ver.TxnIndex); err != nil {
+			return
+		}
+	}
+	partial := len(res.txOut) > 0
+	res.txOut = nil
+	wroteNewPath, err = recordTxnOutput(res, txIO, mvh)
+	return wroteNewPath || partial, err
+}
+
+// firstTxnError: since an incarnation may fail only because it executed speculatively on reads that turn out to be
+//  inconsistent, with ErrorPolicyFailBlock failures are recorded like any other outcome and the block only fails once
+//  it is complete, with the lowest failed transaction the same as serial execution
+func firstTxnError(txIO *TxnInputOutput, incarnation func(txIdx int) int) error {
+	for txIdx := range txIO.errs {
+		if err := txIO.Err(txIdx); err != nil {
+			return &TxnError{Version: Version{TxnIndex: txIdx, Incarnation: incarnation(txIdx)}, Err: err}
+		}
+	}
+	return nil
+}
+
+// abortTxnOutput: cleans up the partial writes of an aborted incarnation. Locations also written by the last recorded
+//  incarnation become estimates since they are expected to be written again, the rest are removed. Returns true if
+//  there were any partial writes.
+func abortTxnOutput(res ExecResult, txIO *TxnInputOutput, mvh *MVHashMap) (bool, error) {
+	prevOut := TxnOutput(txIO.writeSet(res.ver.TxnIndex))
+	for _, v := range res.txOut {
+		var err error
+		if prevOut.hasPath(v.Path) {
+			err = mvh.MarkEstimate(v.Path, res.ver.TxnIndex)
+		} else {
+			err = mvh.Delete(v.Path, res.ver.TxnIndex)
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+	return len(res.txOut) > 0, nil
+}
+
+// markTxnEstimates: the writes of a transaction that failed validation are expected to be written again by its next
+//  incarnation
+func markTxnEstimates(txIdx int, txIO *TxnInputOutput, mvh *MVHashMap) error {
+	for _, v := range txIO.writeSet(txIdx) {
+		if err := mvh.MarkEstimate(v.Path, txIdx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isMVHashMapErr: MVHashMap invariant violations always fail the block whatever the error policy
+func isMVHashMapErr(err error) bool {
+	return errors.Is(err, ErrLowerIncarnation) || errors.Is(err, ErrInvalidKeyCellPath)
+}
+
 type ExecResult struct {
-	err   error
-	ver   Version
-	txIn  TxnInput
-	txOut TxnOutput
+	err      error
+	ver      Version
+	txIn     TxnInput
+	txOut    TxnOutput
+	depIdx   int    // when aborted with errExecAbort, the transaction whose estimate was read ...
+	depPath  []byte // ... and the path it was read from
+	execTime time.Duration
+
+	// only needed for tracing
+	worker    int
+	execStart time.Time
 }
 
 type ExecTask interface {
@@ -55,6 +148,14 @@ type ExecVersionView struct {
 
 	readMap  map[string]ReadDescriptor
 	writeMap map[string]WriteDescriptor
+	depIdx   int
+	depPath  []byte
+
+	// if set, writes are only published to the MVHashMap once execution succeeds
+	bufferWrites bool
+	// first MVHashMap error, which fails the incarnation even if the task ignores it
+	mvErr  error
+	logger Logger
 }
 
 func (ev *ExecVersionView) ensureReadMap() {
@@ -71,24 +172,70 @@ func (ev *ExecVersionView) ensureWriteMap() {
 
 func (ev *ExecVersionView) Execute() (er ExecResult) {
 	er.ver = ev.ver
-	if er.err = ev.et.Execute(ev); er.err != nil {
-		println(fmt.Sprintf("executed task - failed %v.%v, err %v", ev.ver.TxnIndex, ev.ver.Incarnation, er.err))
+	er.depIdx = -1
+	start := time.Now()
+	er.err = executeTask(ev.et, ev, ev.ver)
+	if er.err == nil {
+		ev.publishWrites()
+	}
+	if ev.mvErr != nil {
+		er.err = ev.mvErr
+	}
+	er.execStart, er.execTime = start, time.Since(start)
+	// unless buffered, writes are collected even if execution fails since they are already visible in the MVHashMap
+	if er.err == nil || !ev.bufferWrites {
+		for _, v := range ev.writeMap {
+			er.txOut = append(er.txOut, v)
+		}
+	}
+	if er.err == errExecAbort {
+		er.depIdx, er.depPath = ev.depIdx, ev.depPath
+		if ev.debugEnabled() {
+			ev.logger.Log(LogLevelDebug, "execution aborted", F("tx", ev.ver.TxnIndex), F("incarnation", ev.ver.Incarnation),
+				F("dependency", er.depIdx))
+		}
 		return
 	}
+	// reads are kept for failures too since the failure may depend on them
 	for _, v := range ev.readMap {
 		er.txIn = append(er.txIn, v)
 	}
-	for _, v := range ev.writeMap {
-		er.txOut = append(er.txOut, v)
+	if er.err != nil {
+		if ev.debugEnabled() {
+			ev.logger.Log(LogLevelDebug, "execution failed", F("tx", ev.ver.TxnIndex), F("incarnation", ev.ver.Incarnation),
+				F("err", er.err))
+		}
+		return
+	}
+	if ev.debugEnabled() {
+		ev.logger.Log(LogLevelDebug, "executed task", F("tx", ev.ver.TxnIndex), F("incarnation", ev.ver.Incarnation),
+			F("reads", len(er.txIn)), F("writes", len(er.txOut)))
 	}
-	println(fmt.Sprintf("executed task %v.%v, in %v, out %v", ev.ver.TxnIndex, ev.ver.Incarnation,
-		len(er.txIn), len(er.txOut)))
 	return
 }
 
+// executeTask: a panic in the task is recovered as a *PanicError so it is handled by the error policy like any other
+//  failure rather than taking down the process
+func executeTask(et ExecTask, rw BaseReadWrite, ver Version) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &PanicError{Version: ver, Value: r, Stack: debug.Stack()}
+		}
+	}()
+	return et.Execute(rw)
+}
+
+func (ev *ExecVersionView) debugEnabled() bool {
+	return ev.logger != nil && ev.logger.Enabled(LogLevelDebug)
+}
+
 var errExecAbort = fmt.Errorf("execution aborted with dependency")
 
 func (ev *ExecVersionView) Read(k []byte) (v []byte, err error) {
+	// reads of the transaction's own writes don't depend on any other transaction so aren't recorded
+	if wd, ok := ev.writeMap[string(k)]; ok {
+		return wd.Val, nil
+	}
 	ev.ensureReadMap()
 	res := ev.mvh.Read(k, ev.ver.TxnIndex)
 	var rd ReadDescriptor
@@ -105,6 +252,7 @@ func (ev *ExecVersionView) Read(k []byte) (v []byte, err error) {
 		}
 	case mvReadResultDependency:
 		{
+			ev.depIdx, ev.depPath = res.depIdx, k
 			return nil, errExecAbort
 		}
 	case mvReadResultNone:
@@ -115,20 +263,25 @@ func (ev *ExecVersionView) Read(k []byte) (v []byte, err error) {
 	default:
 		return nil, fmt.Errorf("should not happen - invalid read result status '%ver'", res.status())
 	}
-	mk := base64.StdEncoding.EncodeToString(k)
 	// TODO: I assume we don't want to overwrite an existing read because this could - for example - change a storage
 	//  read to map if the same value is read multiple times.
-	if _, ok := ev.readMap[mk]; !ok {
-		ev.readMap[mk] = rd
+	if _, ok := ev.readMap[string(k)]; !ok {
+		ev.readMap[string(k)] = rd
 	}
 	return
 }
 
 func (ev *ExecVersionView) Write(k, v []byte) error {
 	ev.ensureWriteMap()
-	ev.mvh.Write(k, ev.ver, v)
-	mk := base64.StdEncoding.EncodeToString(k)
-	ev.writeMap[mk] = WriteDescriptor{
+	// unless buffered the location is visible straight away, but only as an estimate. an incarnation can write the same
+	//  location more than once and a value it overwrites can't be told apart from the final one by version.
+	if !ev.bufferWrites {
+		if _, err := ev.mvh.write(k, ev.ver, v, FlagEstimate); err != nil {
+			ev.setMVErr(err)
+			return err
+		}
+	}
+	ev.writeMap[string(k)] = WriteDescriptor{
 		Path: k,
 		V:    ev.ver,
 		Val:  v,
@@ -136,109 +289,219 @@ func (ev *ExecVersionView) Write(k, v []byte) error {
 	return nil
 }
 
-const numGoProcs = 10
+// publishWrites: makes the final writes of a successful incarnation visible to other transactions
+func (ev *ExecVersionView) publishWrites() {
+	for _, v := range ev.writeMap {
+		wasEstimate, err := ev.mvh.write(v.Path, ev.ver, v.Val, FlagDone)
+		if err != nil {
+			ev.setMVErr(err)
+			return
+		}
+		// unbuffered writes are always estimates until now so only buffered ones replace the previous incarnation
+		if wasEstimate && ev.bufferWrites && ev.debugEnabled() {
+			ev.logger.Log(LogLevelDebug, "marking previous estimate as done", F("tx", ev.ver.TxnIndex),
+				F("incarnation", ev.ver.Incarnation))
+		}
+	}
+}
 
-func ExecuteParallel(tasks []ExecTask, rw BaseReadWrite) (lastTxIO *TxnInputOutput, err error) {
+func (ev *ExecVersionView) setMVErr(err error) {
+	if ev.mvErr == nil {
+		ev.mvErr = err
+	}
+}
 
-	chTasks := make(chan ExecVersionView, len(tasks))
-	chResults := make(chan ExecResult, len(tasks))
-	chDone := make(chan bool)
+// CancelledError: execution was abandoned because its context is done. Tasks that were still executing at the time
+// are left to finish in the background and their results are discarded.
+type CancelledError struct {
+	Cause error // the context error
 
-	var cntExec, cntSuccess, cntAbort, cntTotalValidations, cntValidationFail int
+	NumTxns int
+	// Executed is the number of transactions whose latest incarnation had finished executing
+	Executed    int
+	Executions  int64
+	Validations int64
+}
 
-	for i := 0; i < numGoProcs; i++ {
-		go func(procNum int, t chan ExecVersionView) {
-		Loop:
-			for {
-				select {
-				case task := <-t:
-					{
-						res := task.Execute()
-						chResults <- res
-					}
-				case <-chDone:
-					break Loop
-				}
-			}
-			println(fmt.Sprintf("proc done %v", procNum)) // TODO: logging ...
-		}(i, chTasks)
+func (e *CancelledError) Error() string {
+	return fmt.Sprintf("block execution cancelled with %v of %v transactions executed (%v executions, %v validations): %v",
+		e.Executed, e.NumTxns, e.Executions, e.Validations, e.Cause)
+}
+
+func (e *CancelledError) Unwrap() error {
+	return e.Cause
+}
+
+func makeCancelledError(ctx context.Context, executed int, stats ExecStats) *CancelledError {
+	return &CancelledError{
+		Cause:       ctx.Err(),
+		NumTxns:     stats.NumTxns,
+		Executed:    executed,
+		Executions:  stats.Executions,
+		Validations: stats.Validations,
 	}
+}
+
+// ExecuteParallel: executes tasks in parallel, returning the reads and writes of the final incarnation of each, the
+//  resulting state diff of the block and execution statistics. The diff is not applied to rw - see StateDiff.Apply.
+func ExecuteParallel(tasks []ExecTask, rw BaseReadWrite) (lastTxIO *TxnInputOutput, diff StateDiff, stats ExecStats, err error) {
+	return NewExecutor(ExecOptions{}).ExecuteParallel(tasks, rw)
+}
 
-	mvh := MakeMVHashMap()
+// ExecuteParallelContext: as ExecuteParallel but execution is abandoned with a *CancelledError once ctx is done
+func ExecuteParallelContext(ctx context.Context, tasks []ExecTask, rw BaseReadWrite) (lastTxIO *TxnInputOutput, diff StateDiff, stats ExecStats, err error) {
+	return NewExecutor(ExecOptions{}).ExecuteParallelContext(ctx, tasks, rw)
+}
+
+// ExecuteParallel: a central coordinator owns all task status and hands execution tasks to the workers, validating
+//  the results itself
+func (e *Executor) ExecuteParallel(tasks []ExecTask, rw BaseReadWrite) (lastTxIO *TxnInputOutput, diff StateDiff, stats ExecStats, err error) {
+	return e.ExecuteParallelContext(context.Background(), tasks, rw)
+}
+
+func (e *Executor) ExecuteParallelContext(ctx context.Context, tasks []ExecTask, rw BaseReadWrite) (lastTxIO *TxnInputOutput, diff StateDiff, stats ExecStats, err error) {
+
+	start := time.Now()
+	stats.NumTxns, stats.Workers = len(tasks), e.opts.Workers
+	stats.conflicts = makeConflictCounts()
+
+	d := e.startDispatcher(len(tasks))
+
+	var cntInFlight int
+
+	// set if execution stops without waiting for workers that may be stuck in a task
+	var abandoned bool
+	mvh := e.acquireMVHashMap()
+	defer func() { e.releaseMVHashMap(mvh, abandoned) }()
+	// stats are complete by the time this runs, whichever way execution stops
+	defer func() { e.onBlock(stats, mvh, err) }()
 
 	execTasks := makeStatusManager(len(tasks))
 	validateTasks := makeStatusManager(0)
 
-	// bootstrap execution
-	for x := 0; x < numGoProcs; x++ {
-		tx := execTasks.takeNextPending()
-		if tx != -1 {
-			cntExec++
-			chTasks <- ExecVersionView{ver: Version{tx, 0}, et: tasks[tx], rw: rw, mvh: mvh}
+	lastTxIO = MakeTxnInputOutput(len(tasks))
+	txIncarnations := make([]int, len(tasks))
+
+	// aborted transactions parked until the transaction they read an estimate from finishes its next execution
+	dependencies := make(map[int][]int)
+
+	// bumps the incarnation of tx, returning false if that exceeds the maximum
+	bumpIncarnation := func(tx int) bool {
+		txIncarnations[tx]++
+		if e.exceedsMaxIncarnations(Version{tx, txIncarnations[tx]}) {
+			err = fmt.Errorf("%w: tx %v", ErrMaxIncarnations, tx)
+			return false
 		}
+		return true
 	}
 
-	lastTxIO = MakeTxnInputOutput(len(tasks))
-	txIncarnations := make([]int, len(tasks))
+	// keep all workers busy while there are pending tasks
+	queuePending := func() {
+		for cntInFlight < e.opts.Workers {
+			tx := execTasks.takeNextPending()
+			if tx == -1 {
+				break
+			}
+			stats.Executions++
+			cntInFlight++
+			d.dispatch(ExecVersionView{ver: Version{tx, txIncarnations[tx]}, et: tasks[tx], rw: rw, mvh: mvh,
+				bufferWrites: e.opts.BufferWrites, logger: e.opts.Logger})
+		}
+	}
+
+	// bootstrap execution
+	queuePending()
 
 	diagExecSuccess := make([]int, len(tasks))
 	diagExecAbort := make([]int, len(tasks))
 
-	for {
-		res := <-chResults
+	// nothing is ever dispatched for an empty block so there is no result to wait for, only the context to check
+	if len(tasks) == 0 && ctx.Err() != nil {
+		err = makeCancelledError(ctx, 0, stats)
+	}
+
+Loop:
+	for len(tasks) > 0 {
+		res, ok := d.next(ctx)
+		if !ok {
+			err = makeCancelledError(ctx, execTasks.countComplete(), stats)
+			break Loop
+		}
+		cntInFlight--
+		stats.ExecTime += res.execTime
+		e.onExecute(res)
+		if isMVHashMapErr(res.err) {
+			err = &TxnError{Version: res.ver, Err: res.err}
+			break Loop
+		}
 		switch res.err {
-		case nil:
+		case errExecAbort:
 			{
-				lastTxIO.recordRead(res.ver.TxnIndex, res.txIn)
-				if res.ver.Incarnation == 0 {
-					lastTxIO.recordWrite(res.ver.TxnIndex, res.txOut)
-				} else {
-					if res.txOut.hasNewWrite(lastTxIO.writeSet(res.ver.TxnIndex)) {
-						// TODO: 'if there is a write to a memory location ...'
-						// panic("test + implement me")
-						println("TODO: deal with different write sets")
-					}
-					lastTxIO.recordWrite(res.ver.TxnIndex, res.txOut)
+				// partial writes are cleaned up and anything that may have read them has to be validated again
+				var partial bool
+				if partial, err = abortTxnOutput(res, lastTxIO, mvh); err != nil {
+					break Loop
 				}
-				validateTasks.pushPending(res.ver.TxnIndex)
-				execTasks.markComplete(res.ver.TxnIndex)
-				if diagExecSuccess[res.ver.TxnIndex] > 0 && diagExecAbort[res.ver.TxnIndex] == 0 {
-					println("got multiple successful execution w/o abort?", res.ver.TxnIndex, res.ver.Incarnation)
+				if partial {
+					validateTasks.pushPendingSet(execTasks.getRevalidationRange(res.ver.TxnIndex + 1))
+				}
+				// if the dependency has already completed in the meantime then this adds the tx straight back to
+				//  pending, otherwise it is parked until the dependency completes ...
+				if execTasks.checkComplete(res.depIdx) {
+					execTasks.revertInProgress(res.ver.TxnIndex)
+				} else {
+					execTasks.clearInProgress(res.ver.TxnIndex)
+					dependencies[res.depIdx] = append(dependencies[res.depIdx], res.ver.TxnIndex)
 				}
-				diagExecSuccess[res.ver.TxnIndex]++
-				cntSuccess++
-			}
-		case errExecAbort:
-			{
-				// bit of a subtle / tricky bug here. this adds the tx back to pending ...
-				execTasks.revertInProgress(res.ver.TxnIndex)
-				// ... but the incarnation needs to be bumped
-				txIncarnations[res.ver.TxnIndex]++
 				diagExecAbort[res.ver.TxnIndex]++
-				cntAbort++
+				stats.Aborts++
+				stats.conflicts.add(res.depPath, 1, 0, 1)
+				// ... but either way the incarnation needs to be bumped
+				if !bumpIncarnation(res.ver.TxnIndex) {
+					break Loop
+				}
 			}
 		default:
 			{
-				err = res.err
-				break
+				// a recorded failure completes the transaction the same as a success, just without any writes.
+				//  locations written by the previous incarnation but not this one are removed from the MVHashMap ...
+				var wroteNewPath bool
+				if res.err == nil {
+					wroteNewPath, err = recordTxnOutput(res, lastTxIO, mvh)
+					stats.Successes++
+				} else {
+					wroteNewPath, err = recordTxnFailure(res, lastTxIO, mvh)
+					stats.Failures++
+				}
+				if err != nil {
+					break Loop
+				}
+				validateTasks.pushPending(res.ver.TxnIndex)
+				execTasks.markComplete(res.ver.TxnIndex)
+				// ... and if this incarnation wrote to a new location then higher transactions may have missed it
+				if wroteNewPath {
+					validateTasks.pushPendingSet(execTasks.getRevalidationRange(res.ver.TxnIndex + 1))
+				}
+				execTasks.pushPendingSet(dependencies[res.ver.TxnIndex])
+				delete(dependencies, res.ver.TxnIndex)
+				if diagExecSuccess[res.ver.TxnIndex] > 0 && diagExecAbort[res.ver.TxnIndex] == 0 && e.opts.Logger.Enabled(LogLevelWarn) {
+					e.opts.Logger.Log(LogLevelWarn, "multiple successful executions without abort",
+						F("tx", res.ver.TxnIndex), F("incarnation", res.ver.Incarnation))
+				}
+				diagExecSuccess[res.ver.TxnIndex]++
 			}
 		}
 
-		// if we got more work, queue one up...
-		nextTx := execTasks.takeNextPending()
-		if nextTx != -1 {
-			cntExec++
-			chTasks <- ExecVersionView{ver: Version{nextTx, txIncarnations[nextTx]}, et: tasks[nextTx], rw: rw, mvh: mvh}
-		}
+		// if we got more work, queue it up...
+		queuePending()
 
 		// do validations ...
 		maxComplete := execTasks.maxAllComplete()
 
-		const validationIncrement = 5
 		cntValidate := validateTasks.countPending()
-		// if we're currently done with all execution tasks then let's validate everything; otherwise do one increment ...
-		if execTasks.countComplete() != len(tasks) && cntValidate > validationIncrement {
-			cntValidate = validationIncrement
+		// if we're currently done with all execution tasks then let's validate everything; otherwise do one batch ...
+		if batch := d.validationBatch(e.opts.ValidationBatch); execTasks.countComplete() != len(tasks) && cntValidate > batch {
+			cntValidate = batch
 		}
 		var toValidate []int
 		for i := 0; i < cntValidate; i++ {
@@ -250,52 +513,73 @@ func ExecuteParallel(tasks []ExecTask, rw BaseReadWrite) (lastTxIO *TxnInputOutp
 		}
 
 		for i := 0; i < len(toValidate); i++ {
-			cntTotalValidations++
+			stats.Validations++
 			tx := toValidate[i]
-			if validateVersion(tx, lastTxIO, mvh) {
-				println(fmt.Sprintf("* completed validation task %v", tx))
+			validateStart := time.Now()
+			valid, invalidPath := validateVersion(tx, lastTxIO, mvh)
+			validateTime := time.Since(validateStart)
+			stats.ValidationTime += validateTime
+			e.onValidate(TraceCoordinator, Version{tx, txIncarnations[tx]}, valid, invalidPath, validateStart, validateTime)
+			if valid {
+				if e.opts.Logger.Enabled(LogLevelDebug) {
+					e.opts.Logger.Log(LogLevelDebug, "validated task", F("tx", tx), F("incarnation", txIncarnations[tx]))
+				}
 				validateTasks.markComplete(tx)
 			} else {
-				println(fmt.Sprintf("* validation task FAILED %v", tx))
-				cntValidationFail++
+				if e.opts.Logger.Enabled(LogLevelDebug) {
+					e.opts.Logger.Log(LogLevelDebug, "validation failed", F("tx", tx), F("incarnation", txIncarnations[tx]))
+				}
+				stats.ValidationFailures++
 				diagExecAbort[tx]++
-				for _, v := range lastTxIO.writeSet(tx) {
-					mvh.MarkEstimate(v.Path, tx)
+				if err = markTxnEstimates(tx, lastTxIO, mvh); err != nil {
+					break Loop
 				}
 				// 'create validation tasks for all transactions > tx ...'
 				validateTasks.pushPendingSet(execTasks.getRevalidationRange(tx + 1))
 				validateTasks.clearInProgress(tx) // clear in progress - pending will be added again once new incarnation executes
 				if execTasks.checkPending(tx) {
 					// println() // have to think about this ...
+					stats.conflicts.add(invalidPath, 0, 1, 0)
 				} else {
+					stats.conflicts.add(invalidPath, 0, 1, 1)
 					execTasks.pushPending(tx)
 					execTasks.clearComplete(tx)
-					txIncarnations[tx]++
+					if !bumpIncarnation(tx) {
+						break Loop
+					}
 				}
 			}
 		}
 
-		// if we didn't queue work previously, do check again so we keep making progress ...
-		if nextTx == -1 {
-			nextTx = execTasks.takeNextPending()
-			if nextTx != -1 {
-				cntExec++
-				chTasks <- ExecVersionView{ver: Version{nextTx, txIncarnations[nextTx]}, et: tasks[nextTx], rw: rw, mvh: mvh}
-			}
-		}
+		// validation failures may have added work so check again so we keep making progress ...
+		queuePending()
 
 		if validateTasks.countComplete() == len(tasks) && execTasks.countComplete() == len(tasks) {
-			println(fmt.Sprintf("exec summary: %v execs: %v success, %v aborts; %v validations: %v failures",
-				cntExec, cntSuccess, cntAbort, cntTotalValidations, cntValidationFail))
 			break
 		}
 	}
 
-	for i := 0; i < numGoProcs; i++ {
-		chDone <- true
+	// stop scheduling - but don't wait for workers that may be stuck in a task if cancelled
+	_, abandoned = err.(*CancelledError)
+	d.stop(abandoned)
+	if abandoned {
+		lastTxIO = nil
+		stats.finish(start, stats.ExecTime, nil)
+		return
+	}
+
+	// validation is done by the coordinator so workers are only busy executing
+	stats.finish(start, stats.ExecTime, func(txIdx int) int { return txIncarnations[txIdx] })
+	if e.opts.Logger.Enabled(LogLevelInfo) {
+		e.opts.Logger.Log(LogLevelInfo, "exec summary", stats.fields()...)
+	}
+
+	if err == nil && e.opts.ErrorPolicy == ErrorPolicyFailBlock {
+		err = firstTxnError(lastTxIO, func(txIdx int) int { return txIncarnations[txIdx] })
+	}
+	if err == nil {
+		diff = mvh.StateDiff()
 	}
-	close(chTasks)
-	close(chResults)
 
 	return
 }
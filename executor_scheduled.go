@@ -0,0 +1,185 @@
+package block_stm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ExecuteScheduled: alternative to ExecuteParallel without a central coordinator - every worker pulls its own
+//  execution and validation tasks from a shared Scheduler and reports back to it directly. The returned TxnInputOutput
+//  and StateDiff are the same as ExecuteParallel so the two can be compared.
+func ExecuteScheduled(tasks []ExecTask, rw BaseReadWrite) (lastTxIO *TxnInputOutput, diff StateDiff, stats ExecStats, err error) {
+	return NewExecutor(ExecOptions{}).ExecuteScheduled(tasks, rw)
+}
+
+// ExecuteScheduledContext: as ExecuteScheduled but execution is abandoned with a *CancelledError once ctx is done
+func ExecuteScheduledContext(ctx context.Context, tasks []ExecTask, rw BaseReadWrite) (lastTxIO *TxnInputOutput, diff StateDiff, stats ExecStats, err error) {
+	return NewExecutor(ExecOptions{}).ExecuteScheduledContext(ctx, tasks, rw)
+}
+
+func (e *Executor) ExecuteScheduled(tasks []ExecTask, rw BaseReadWrite) (lastTxIO *TxnInputOutput, diff StateDiff, stats ExecStats, err error) {
+	return e.ExecuteScheduledContext(context.Background(), tasks, rw)
+}
+
+func (e *Executor) ExecuteScheduledContext(ctx context.Context, tasks []ExecTask, rw BaseReadWrite) (lastTxIO *TxnInputOutput, diff StateDiff, stats ExecStats, err error) {
+
+	start := time.Now()
+	// updated concurrently by the workers, which may still be running if cancelled
+	cnt := ExecStats{NumTxns: len(tasks), Workers: e.opts.Workers, conflicts: makeConflictCounts()}
+
+	sched := MakeScheduler(len(tasks))
+	// set if execution stops without waiting for workers that may be stuck in a task
+	var abandoned bool
+	mvh := e.acquireMVHashMap()
+	defer func() { e.releaseMVHashMap(mvh, abandoned) }()
+	// stats are complete by the time this runs, whichever way execution stops
+	defer func() { e.onBlock(stats, mvh, err) }()
+	txIO := MakeTxnInputOutput(len(tasks))
+
+	var errOnce sync.Once
+	fail := func(failErr error) {
+		errOnce.Do(func() {
+			err = failErr
+			sched.halt()
+		})
+	}
+
+	tryExecute := func(worker int, ver Version) SchedulerTask {
+		for {
+			if e.exceedsMaxIncarnations(ver) {
+				fail(fmt.Errorf("%w: tx %v", ErrMaxIncarnations, ver.TxnIndex))
+				return nil
+			}
+			ev := ExecVersionView{ver: ver, et: tasks[ver.TxnIndex], rw: rw, mvh: mvh,
+				bufferWrites: e.opts.BufferWrites, logger: e.opts.Logger}
+			res := ev.Execute()
+			res.worker = worker
+			atomic.AddInt64(&cnt.Executions, 1)
+			atomic.AddInt64((*int64)(&cnt.ExecTime), int64(res.execTime))
+			e.onExecute(res)
+			switch res.err {
+			case nil:
+				atomic.AddInt64(&cnt.Successes, 1)
+				wroteNewPath, err := recordTxnOutput(res, txIO, mvh)
+				if err != nil {
+					fail(err)
+					return nil
+				}
+				return sched.FinishExecution(ver, wroteNewPath)
+			case errExecAbort:
+				atomic.AddInt64(&cnt.Aborts, 1)
+				// the transaction executes again whether now or once its dependency is done
+				cnt.conflicts.add(res.depPath, 1, 0, 1)
+				// anything that read the partial writes of this incarnation has to be validated again
+				partial, err := abortTxnOutput(res, txIO, mvh)
+				if err != nil {
+					fail(err)
+					return nil
+				}
+				if partial {
+					sched.decreaseValidationIdx(ver.TxnIndex + 1)
+				}
+				if sched.AddDependency(ver.TxnIndex, res.depIdx) {
+					return nil
+				}
+				ver = sched.reincarnate(ver.TxnIndex)
+			default:
+				if !isMVHashMapErr(res.err) {
+					atomic.AddInt64(&cnt.Failures, 1)
+					wroteNewPath, err := recordTxnFailure(res, txIO, mvh)
+					if err != nil {
+						fail(err)
+						return nil
+					}
+					return sched.FinishExecution(ver, wroteNewPath)
+				}
+				fail(&TxnError{Version: ver, Err: res.err})
+				return nil
+			}
+		}
+	}
+
+	needsReexecution := func(worker int, ver Version) SchedulerTask {
+		validateStart := time.Now()
+		valid, invalidPath := validateVersion(ver.TxnIndex, txIO, mvh)
+		validateTime := time.Since(validateStart)
+		atomic.AddInt64((*int64)(&cnt.ValidationTime), int64(validateTime))
+		e.onValidate(worker, ver, valid, invalidPath, validateStart, validateTime)
+		atomic.AddInt64(&cnt.Validations, 1)
+		if !valid {
+			atomic.AddInt64(&cnt.ValidationFailures, 1)
+		}
+		aborted := !valid && sched.TryValidationAbort(ver)
+		if !valid {
+			if aborted {
+				cnt.conflicts.add(invalidPath, 0, 1, 1)
+			} else {
+				cnt.conflicts.add(invalidPath, 0, 1, 0)
+			}
+		}
+		if aborted {
+			if err := markTxnEstimates(ver.TxnIndex, txIO, mvh); err != nil {
+				fail(err)
+				return nil
+			}
+		}
+		return sched.FinishValidation(ver.TxnIndex, aborted)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < e.opts.Workers; i++ {
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			var task SchedulerTask
+			for !sched.Done() {
+				switch t := task.(type) {
+				case SchedulerTaskExecution:
+					task = tryExecute(worker, t.Version)
+				case SchedulerTaskValidation:
+					task = needsReexecution(worker, t.Version)
+				default:
+					task = sched.NextTask()
+				}
+			}
+		}(i)
+	}
+
+	chWorkersDone := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(chWorkersDone)
+	}()
+
+	select {
+	case <-chWorkersDone:
+	case <-ctx.Done():
+		// workers stop at their next task but don't wait for any that may be stuck in one. if the block has already
+		//  failed that error is returned, but the workers are abandoned all the same
+		abandoned = true
+		stats = cnt.load()
+		fail(makeCancelledError(ctx, sched.countExecuted(), stats))
+		stats.finish(start, stats.ExecTime+stats.ValidationTime, nil)
+		return nil, nil, stats, err
+	}
+
+	// workers do both execution and validation
+	stats = cnt
+	stats.finish(start, stats.ExecTime+stats.ValidationTime, func(txIdx int) int { return sched.txnStatus[txIdx].incarnation })
+	if e.opts.Logger.Enabled(LogLevelInfo) {
+		e.opts.Logger.Log(LogLevelInfo, "scheduled exec summary", stats.fields()...)
+	}
+
+	lastTxIO = txIO
+	if err == nil && e.opts.ErrorPolicy == ErrorPolicyFailBlock {
+		err = firstTxnError(txIO, func(txIdx int) int { return sched.txnStatus[txIdx].incarnation })
+	}
+	if err == nil {
+		diff = mvh.StateDiff()
+	}
+
+	return
+}
@@ -1,10 +1,13 @@
 package block_stm
 
 import (
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"github.com/stretchr/testify/require"
 	"math/rand"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -38,7 +41,7 @@ func TestSimpleDependency(t *testing.T) {
 	inp2 := []ReadDescriptor{{p2, ReadKindStorage, Version{2, 1}}}
 	lastTxIO.recordRead(2, inp2)
 
-	valid := validateVersion(2, lastTxIO, mvh)
+	valid, _ := validateVersion(2, lastTxIO, mvh)
 	require.False(t, valid, "tx2 sees dependency on tx1 write") // would cause re-exec and re-validation of tx2
 
 	// tx2 now 're-executes' - new incarnation
@@ -49,7 +52,7 @@ func TestSimpleDependency(t *testing.T) {
 	inp2 = []ReadDescriptor{{p2, ReadKindMap, Version{2, 2}}}
 	lastTxIO.recordRead(2, inp2)
 
-	valid = validateVersion(2, lastTxIO, mvh)
+	valid, _ = validateVersion(2, lastTxIO, mvh)
 	require.True(t, valid, "tx2 is complete since dep on tx1 is satisfied")
 
 }
@@ -76,10 +79,10 @@ type testSerialExecTask struct {
 }
 
 func (t testSerialExecTask) Execute(rw BaseReadWrite) error {
-	time.Sleep(t.wait)
 	if _, err := rw.Read([]byte(fmt.Sprintf("test-key-%v", t.num))); err != nil {
 		return err
 	}
+	time.Sleep(t.wait)
 	return rw.Write([]byte(fmt.Sprintf("test-key-%v", t.num+1)), []byte(fmt.Sprintf("test-val-%v", t.num+1)))
 }
 
@@ -91,18 +94,48 @@ type testConflictExecTask struct {
 // this simulates each task reading from and incrementing the same counter
 func (t testConflictExecTask) Execute(rw BaseReadWrite) error {
 	var cnt uint32
-	time.Sleep(t.wait)
 	if v, err := rw.Read([]byte("test-key-0")); err != nil {
 		return err
 	} else {
 		cnt = binary.BigEndian.Uint32(v)
 	}
+	time.Sleep(t.wait)
 	var b [4]byte
 	cnt++
 	binary.BigEndian.PutUint32(b[:], cnt)
 	return rw.Write([]byte("test-key-0"), b[:])
 }
 
+type testCountingExecTask struct {
+	ExecTask
+	cnt *int32
+}
+
+func (t testCountingExecTask) Execute(rw BaseReadWrite) error {
+	atomic.AddInt32(t.cnt, 1)
+	return t.ExecTask.Execute(rw)
+}
+
+type testDoubleIncrementExecTask struct {
+	testExecTask
+}
+
+// increments the same counter twice so the second read has to see the first write
+func (t testDoubleIncrementExecTask) Execute(rw BaseReadWrite) error {
+	for i := 0; i < 2; i++ {
+		v, err := rw.Read([]byte("test-key-0"))
+		if err != nil {
+			return err
+		}
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], binary.BigEndian.Uint32(v)+1)
+		if err = rw.Write([]byte("test-key-0"), b[:]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 var _ ExecTask = &testSerialExecTask{}
 var _ ExecTask = &testConflictExecTask{}
 
@@ -122,6 +155,13 @@ func (t testBaseReadWrite) Write(k, v []byte) error {
 
 var _ BaseReadWrite = &testBaseReadWrite{}
 
+type testExecuteFunc func([]ExecTask, BaseReadWrite) (*TxnInputOutput, StateDiff, ExecStats, error)
+
+// testExecutors: the parallel executors of e, which tests run the same block through to check they agree
+func testExecutors(e *Executor) []testExecuteFunc {
+	return []testExecuteFunc{e.ExecuteParallel, e.ExecuteScheduled}
+}
+
 func validateIndependentTxOutput(txIO *TxnInputOutput) bool {
 	seq := uint32(0)
 	for _, v := range txIO.outputs {
@@ -177,6 +217,310 @@ func TestConflictParallel(t *testing.T) {
 	testParallelScenario(t, exec, totalTaskDuration, validateConflictTxOutput)
 }
 
+// tasks abort as soon as they read an estimate so without parking them on their dependency they would be re-executed
+//  over and over until the dependency clears
+func TestConflictDependencyParking(t *testing.T) {
+	const numTasks = 50
+	var rw testBaseReadWrite
+
+	for _, execute := range testExecutors(NewExecutor(ExecOptions{})) {
+		var cntExec int32
+		var exec []ExecTask
+		for i := 0; i < numTasks; i++ {
+			exec = append(exec, testCountingExecTask{
+				ExecTask: testConflictExecTask{testExecTask: testExecTask{num: i, wait: 5 * time.Millisecond}},
+				cnt:      &cntExec,
+			})
+		}
+		txIO, _, _, err := execute(exec, &rw)
+		require.NoError(t, err)
+		require.True(t, validateConflictTxOutput(txIO))
+		require.Less(t, int(cntExec), numTasks*defaultNumWorkers)
+		t.Logf("%v tasks, %v executions", numTasks, cntExec)
+	}
+}
+
+type testRecordingReadWrite struct {
+	testBaseReadWrite
+	writes []string
+}
+
+func (t *testRecordingReadWrite) Write(k, v []byte) error {
+	t.writes = append(t.writes, string(k))
+	return nil
+}
+
+func TestStateDiff(t *testing.T) {
+	var exec []ExecTask
+	for i := 0; i < 20; i++ {
+		exec = append(exec, testSerialExecTask{testExecTask: testExecTask{num: i}})
+	}
+	exec = append(exec, testConflictExecTask{}, testConflictExecTask{})
+
+	var rw testRecordingReadWrite
+	_, diff, _, err := ExecuteParallel(exec, &rw)
+	require.NoError(t, err)
+
+	// test-key-1 ... test-key-20 written by the serial tasks, test-key-0 by both conflict tasks
+	require.Equal(t, 21, len(diff))
+	for i := 1; i < len(diff); i++ {
+		require.Less(t, string(diff[i-1].Path), string(diff[i].Path), "diff should be ordered by path")
+	}
+	require.Equal(t, []byte("test-key-0"), diff[0].Path)
+	require.Equal(t, 21, diff[0].V.TxnIndex, "final write is from the highest tx")
+	require.Equal(t, uint32(2), binary.BigEndian.Uint32(diff[0].Val))
+
+	require.Empty(t, rw.writes, "diff is not applied by execution")
+	require.NoError(t, diff.Apply(&rw))
+	require.Equal(t, len(diff), len(rw.writes))
+	for i, v := range diff {
+		require.Equal(t, string(v.Path), rw.writes[i])
+	}
+}
+
+type testBranchExecTask struct {
+	testExecTask
+}
+
+// tx0 sets a flag after a delay, every other tx writes to a different path depending on the flag it reads
+func (t testBranchExecTask) Execute(rw BaseReadWrite) error {
+	if t.num == 0 {
+		time.Sleep(t.wait)
+		return rw.Write([]byte("test-flag"), []byte{0, 0, 0, 1})
+	}
+	v, err := rw.Read([]byte("test-flag"))
+	if err != nil {
+		return err
+	}
+	branch := "a"
+	if binary.BigEndian.Uint32(v) != 0 {
+		branch = "b"
+	}
+	return rw.Write([]byte(fmt.Sprintf("test-branch-%v-%v", branch, t.num)), v)
+}
+
+func TestChangingWriteSet(t *testing.T) {
+	const numTasks = 20
+	var exec []ExecTask
+	for i := 0; i < numTasks; i++ {
+		exec = append(exec, testBranchExecTask{testExecTask{num: i, wait: 20 * time.Millisecond}})
+	}
+
+	var rw testBaseReadWrite
+	for _, execute := range testExecutors(NewExecutor(ExecOptions{})) {
+		txIO, diff, _, err := execute(exec, &rw)
+		require.NoError(t, err)
+
+		// writes of earlier incarnations to the 'a' branch must be gone
+		require.Equal(t, numTasks, len(diff))
+		for i := 1; i < numTasks; i++ {
+			require.Equal(t, []byte(fmt.Sprintf("test-branch-b-%v", i)), txIO.writeSet(i)[0].Path)
+		}
+		for _, v := range diff {
+			require.NotContains(t, string(v.Path), "test-branch-a")
+		}
+	}
+}
+
+func TestReadOwnWrites(t *testing.T) {
+	mvh := MakeMVHashMap()
+	require.NoError(t, mvh.Write([]byte("test-key-0"), Version{0, 0}, []byte{0, 0, 0, 5}))
+
+	ev := ExecVersionView{ver: Version{1, 0}, et: testDoubleIncrementExecTask{}, rw: testBaseReadWrite{}, mvh: mvh}
+	res := ev.Execute()
+	require.NoError(t, res.err)
+
+	require.Equal(t, 1, len(res.txIn), "read of own write is not recorded")
+	require.Equal(t, ReadDescriptor{Path: []byte("test-key-0"), Kind: ReadKindMap, V: Version{0, 0}}, res.txIn[0])
+	require.Equal(t, 1, len(res.txOut))
+	require.Equal(t, uint32(7), binary.BigEndian.Uint32(res.txOut[0].Val))
+
+	var exec []ExecTask
+	for i := 0; i < 20; i++ {
+		exec = append(exec, testDoubleIncrementExecTask{})
+	}
+	_, diff, _, err := ExecuteParallel(exec, testBaseReadWrite{})
+	require.NoError(t, err)
+	require.Equal(t, uint32(40), binary.BigEndian.Uint32(diff[0].Val))
+}
+
+func TestWritesEstimateUntilPublished(t *testing.T) {
+	mvh := MakeMVHashMap()
+	k := []byte("test-key-0")
+
+	// an intermediate value is never read by another transaction, it has the same version as the final one so its
+	//  readers could not be caught by validation
+	ev := ExecVersionView{ver: Version{0, 0}, mvh: mvh}
+	require.NoError(t, ev.Write(k, []byte("intermediate")))
+	require.Equal(t, mvReadResultDependency, mvh.Read(k, 1).status())
+	require.NoError(t, ev.Write(k, []byte("final")))
+	require.Equal(t, mvReadResultDependency, mvh.Read(k, 1).status())
+
+	ev.publishWrites()
+	res := mvh.Read(k, 1)
+	require.Equal(t, mvReadResultDone, res.status())
+	require.Equal(t, []byte("final"), res.value)
+}
+
+func TestRecordTxnFailurePartialWrites(t *testing.T) {
+	mvh := MakeMVHashMap()
+	txIO := MakeTxnInputOutput(2)
+	k := []byte("test-key-0")
+
+	// the partial write was visible while the incarnation executed so higher transactions may have read it
+	ver := Version{0, 0}
+	require.NoError(t, mvh.Write(k, ver, []byte{1}))
+	res := ExecResult{err: errTestRevert, ver: ver, txOut: TxnOutput{{Path: k, V: ver, Val: []byte{1}}}}
+	wroteNewPath, err := recordTxnFailure(res, txIO, mvh)
+	require.NoError(t, err)
+	require.True(t, wroteNewPath)
+	require.Equal(t, mvReadResultNone, mvh.Read(k, 1).status())
+	require.Empty(t, txIO.writeSet(0))
+
+	res = ExecResult{err: errTestRevert, ver: Version{0, 1}}
+	wroteNewPath, err = recordTxnFailure(res, txIO, mvh)
+	require.NoError(t, err)
+	require.False(t, wroteNewPath)
+}
+
+type testIgnoreWriteErrExecTask struct{}
+
+func (t testIgnoreWriteErrExecTask) Execute(rw BaseReadWrite) error {
+	_ = rw.Write([]byte("test-key-0"), []byte{1})
+	return nil
+}
+
+func TestMVHashMapErrPropagation(t *testing.T) {
+	for _, bufferWrites := range []bool{false, true} {
+		mvh := MakeMVHashMap()
+		require.NoError(t, mvh.Write([]byte("test-key-0"), Version{1, 2}, []byte{0}))
+
+		// a stale incarnation fails even though the task ignores the error
+		ev := ExecVersionView{ver: Version{1, 1}, et: testIgnoreWriteErrExecTask{}, rw: testBaseReadWrite{}, mvh: mvh,
+			bufferWrites: bufferWrites}
+		res := ev.Execute()
+		require.ErrorIs(t, res.err, ErrLowerIncarnation)
+		require.True(t, isMVHashMapErr(res.err))
+	}
+}
+
+func makeTestBufferWritesTasks() (exec []ExecTask) {
+	for i := 0; i < 100; i++ {
+		exec = append(exec, testConflictExecTask{testExecTask{num: i, wait: time.Duration(rand.Intn(10)) * time.Millisecond}})
+	}
+	return
+}
+
+func TestBufferWrites(t *testing.T) {
+	exec := makeTestBufferWritesTasks()
+
+	var rw testBaseReadWrite
+	for _, bufferWrites := range []bool{false, true} {
+		e := NewExecutor(ExecOptions{BufferWrites: bufferWrites})
+		for _, execute := range testExecutors(e) {
+			txIO, _, _, err := execute(exec, &rw)
+			require.NoError(t, err)
+			require.True(t, validateConflictTxOutput(txIO))
+		}
+	}
+}
+
+// compares how often transactions are aborted and fail validation with and without buffered writes, for example
+//  go test -run XXX -bench BenchmarkBufferWrites
+func BenchmarkBufferWrites(b *testing.B) {
+	exec := makeTestBufferWritesTasks()
+
+	var rw testBaseReadWrite
+	for _, bufferWrites := range []bool{false, true} {
+		e := NewExecutor(ExecOptions{BufferWrites: bufferWrites})
+		for n, execute := range testExecutors(e) {
+			b.Run(fmt.Sprintf("%v/buffered-%v", []string{"parallel", "scheduled"}[n], bufferWrites), func(b *testing.B) {
+				var validationFailures, aborts int64
+				for i := 0; i < b.N; i++ {
+					_, _, stats, err := execute(exec, &rw)
+					require.NoError(b, err)
+					validationFailures += stats.ValidationFailures
+					aborts += stats.Aborts
+				}
+				b.ReportMetric(float64(validationFailures)/float64(b.N), "validationFailures/op")
+				b.ReportMetric(float64(aborts)/float64(b.N), "aborts/op")
+			})
+		}
+	}
+}
+
+type testBlockingExecTask struct {
+	ExecTask
+	release chan struct{}
+}
+
+func (t testBlockingExecTask) Execute(rw BaseReadWrite) error {
+	<-t.release
+	return t.ExecTask.Execute(rw)
+}
+
+func TestContextCancellation(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	var exec []ExecTask
+	for i := 0; i < 100; i++ {
+		exec = append(exec, testIndependentExecTask{testExecTask{num: i}})
+	}
+	// one task never finishes until the test ends so execution can only stop via the context
+	exec[50] = testBlockingExecTask{exec[50], release}
+
+	var rw testBaseReadWrite
+	for _, execute := range []func(context.Context, []ExecTask, BaseReadWrite) (*TxnInputOutput, StateDiff, ExecStats, error){
+		ExecuteParallelContext, ExecuteScheduledContext} {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		start := time.Now()
+		txIO, diff, _, err := execute(ctx, exec, &rw)
+		cancel()
+		require.Less(t, time.Since(start), time.Second)
+
+		require.Nil(t, txIO)
+		require.Nil(t, diff)
+		require.True(t, errors.Is(err, context.DeadlineExceeded))
+		var cancelErr *CancelledError
+		require.True(t, errors.As(err, &cancelErr))
+		require.Equal(t, len(exec), cancelErr.NumTxns)
+		require.Less(t, cancelErr.Executed, len(exec))
+		require.Greater(t, cancelErr.Executions, int64(0))
+	}
+}
+
+func TestEmptyBlock(t *testing.T) {
+	var rw testBaseReadWrite
+	var blocks []BlockEvent
+	e := NewExecutor(ExecOptions{Hooks: ExecHooks{OnBlock: func(ev BlockEvent) { blocks = append(blocks, ev) }}})
+	for _, execute := range []func(context.Context, []ExecTask, BaseReadWrite) (*TxnInputOutput, StateDiff, ExecStats, error){
+		e.ExecuteParallelContext, e.ExecuteScheduledContext} {
+		// the deadline is only there so a hang fails rather than blocking the test
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		blocks = nil
+		txIO, diff, stats, err := execute(ctx, nil, &rw)
+		cancel()
+
+		require.NoError(t, err)
+		require.NotNil(t, txIO)
+		require.Empty(t, diff)
+		require.Equal(t, 0, stats.NumTxns)
+		require.Equal(t, e.Options().Workers, stats.Workers)
+
+		// the same as any other block as far as hooks are concerned
+		require.Len(t, blocks, 1)
+		require.Equal(t, stats, blocks[0].Stats)
+		require.NoError(t, blocks[0].Err)
+	}
+
+	// there is nothing to execute but a context that is already done still cancels the block
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, _, err := e.ExecuteParallelContext(ctx, nil, &rw)
+	require.ErrorIs(t, err, context.Canceled)
+}
+
 func validateSerialTxOutput(txIO *TxnInputOutput) bool {
 	seq := uint32(1)
 	for _, v := range txIO.outputs {
@@ -210,7 +554,7 @@ func testParallelScenario(t *testing.T, exec []ExecTask, totalTaskDuration time.
 	var rw testBaseReadWrite
 
 	start := time.Now()
-	txIO, err := ExecuteParallel(exec, &rw)
+	txIO, diff, _, err := ExecuteParallel(exec, &rw)
 	execDuration := time.Since(start)
 	require.NoError(t, err)
 
@@ -220,4 +564,25 @@ func testParallelScenario(t *testing.T, exec []ExecTask, totalTaskDuration time.
 	println(fmt.Sprintf("exec duration %v, total duration %v", execDuration, totalTaskDuration))
 
 	require.True(t, validateTxIO(txIO))
+
+	start = time.Now()
+	txIO, diffScheduled, _, err := ExecuteScheduled(exec, &rw)
+	execDuration = time.Since(start)
+	require.NoError(t, err)
+
+	t.Logf("scheduled exec duration %v, total duration %v", execDuration, totalTaskDuration)
+
+	require.True(t, validateTxIO(txIO))
+
+	require.Equal(t, len(diff), len(diffScheduled))
+	for i := range diff {
+		require.Equal(t, diff[i].Path, diffScheduled[i].Path)
+		require.Equal(t, diff[i].V.TxnIndex, diffScheduled[i].V.TxnIndex)
+		require.Equal(t, diff[i].Val, diffScheduled[i].Val)
+	}
+
+	_, diffSerial, _, err := ExecuteSerial(exec, &rw)
+	require.NoError(t, err)
+	require.Empty(t, Compare(diffSerial, diff))
+	require.Empty(t, Compare(diffSerial, diffScheduled))
 }
@@ -0,0 +1,158 @@
+package block_stm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+const (
+	testOpRead      = 0 // reg = key
+	testOpWrite     = 1 // key = reg + arg
+	testOpAdd       = 2 // reg += next reg + arg
+	testOpCondWrite = 3 // key = reg + arg, if reg is even
+	testOpFail      = 4 // fail, if reg is a multiple of arg
+	testNumOps      = 5
+
+	testNumRegs = 4
+)
+
+var errTestProgramFail = errors.New("program failed")
+
+type testOp struct {
+	code int
+	key  int
+	reg  int
+	arg  uint64
+}
+
+// testProgram: a small interpreted transaction. Reads and writes are over a shared key space so programs conflict,
+//  and conditional writes and failures depend on the values read so they change between incarnations.
+type testProgram struct {
+	ops  []testOp
+	wait time.Duration
+}
+
+func testProgramKey(key int) []byte {
+	return []byte(fmt.Sprintf("test-key-%v", key))
+}
+
+func (p testProgram) Execute(rw BaseReadWrite) error {
+	var regs [testNumRegs]uint64
+	write := func(op testOp) error {
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], regs[op.reg]+op.arg)
+		return rw.Write(testProgramKey(op.key), b[:])
+	}
+	for i, op := range p.ops {
+		switch op.code {
+		case testOpRead:
+			v, err := rw.Read(testProgramKey(op.key))
+			if err != nil {
+				return err
+			}
+			switch len(v) {
+			case 8:
+				regs[op.reg] = binary.BigEndian.Uint64(v)
+			case 4:
+				regs[op.reg] = uint64(binary.BigEndian.Uint32(v))
+			}
+		case testOpWrite:
+			if err := write(op); err != nil {
+				return err
+			}
+		case testOpAdd:
+			regs[op.reg] += regs[(op.reg+1)%testNumRegs] + op.arg
+		case testOpCondWrite:
+			if regs[op.reg]%2 == 0 {
+				if err := write(op); err != nil {
+					return err
+				}
+			}
+		case testOpFail:
+			if regs[op.reg]%op.arg == 0 {
+				return errTestProgramFail
+			}
+		}
+		if i == len(p.ops)/2 {
+			time.Sleep(p.wait)
+		}
+	}
+	return nil
+}
+
+// makeTestPrograms: random programs from a seed, so any failure can be reproduced from the seed alone
+func makeTestPrograms(seed int64, numTxns, numKeys, maxOps int) (exec []ExecTask) {
+	r := rand.New(rand.NewSource(seed))
+	for i := 0; i < numTxns; i++ {
+		p := testProgram{wait: time.Duration(r.Intn(100)) * time.Microsecond}
+		numOps := 1 + r.Intn(maxOps)
+		for j := 0; j < numOps; j++ {
+			op := testOp{code: r.Intn(testNumOps), key: r.Intn(numKeys), reg: r.Intn(testNumRegs), arg: uint64(r.Intn(10))}
+			if op.code == testOpFail {
+				// failures should be the exception
+				op.arg += 7
+			}
+			p.ops = append(p.ops, op)
+		}
+		exec = append(exec, p)
+	}
+	return
+}
+
+// checkAgainstSerial: every executor configuration has to produce the same state diff and transaction failures as
+//  serial execution
+func checkAgainstSerial(t *testing.T, exec []ExecTask) {
+	var rw testBaseReadWrite
+	for _, opts := range []ExecOptions{
+		{},
+		{BufferWrites: true},
+		{ErrorPolicy: ErrorPolicyRecordFailure},
+		{ErrorPolicy: ErrorPolicyRecordFailure, BufferWrites: true, Workers: 3},
+	} {
+		e := NewExecutor(opts)
+		serialTxIO, serialDiff, _, serialErr := e.ExecuteSerial(exec, &rw)
+
+		for _, execute := range testExecutors(e) {
+			txIO, diff, _, err := execute(exec, &rw)
+			if serialErr != nil {
+				var serialTxErr, txErr *TxnError
+				require.ErrorAs(t, serialErr, &serialTxErr)
+				require.ErrorAs(t, err, &txErr, "options %+v", opts)
+				require.Equal(t, serialTxErr.Version.TxnIndex, txErr.Version.TxnIndex, "options %+v", opts)
+				continue
+			}
+			require.NoError(t, err, "options %+v", opts)
+			require.Empty(t, Compare(serialDiff, diff), "options %+v", opts)
+			for i := range exec {
+				require.Equal(t, serialTxIO.Err(i), txIO.Err(i), "tx %v, options %+v", i, opts)
+			}
+		}
+	}
+}
+
+func TestRandomPrograms(t *testing.T) {
+	for seed := int64(0); seed < 20; seed++ {
+		t.Run(fmt.Sprintf("seed-%v", seed), func(t *testing.T) {
+			checkAgainstSerial(t, makeTestPrograms(seed, 50, 1+int(seed%8), 8))
+		})
+	}
+}
+
+// go test -run XXX -fuzz FuzzExecuteParallel
+func FuzzExecuteParallel(f *testing.F) {
+	f.Add(int64(1), uint8(20), uint8(1), uint8(4))
+	f.Add(int64(2), uint8(50), uint8(4), uint8(8))
+	f.Add(int64(3), uint8(100), uint8(16), uint8(12))
+	f.Add(int64(4), uint8(0), uint8(4), uint8(8))
+	f.Fuzz(func(t *testing.T, seed int64, numTxns, numKeys, maxOps uint8) {
+		if numKeys == 0 || maxOps == 0 {
+			t.Skip()
+		}
+		checkAgainstSerial(t, makeTestPrograms(seed, int(numTxns), int(numKeys), int(maxOps)))
+	})
+}
@@ -4,7 +4,6 @@ go 1.18
 
 require (
 	github.com/0xPolygon/eth-state-transition v0.0.0-20220112205905-11f288a4b5d3
-	github.com/emirpasic/gods v1.18.1
 	github.com/holiman/uint256 v1.2.0
 	github.com/ledgerwatch/erigon v0.0.0-20210604162528-1f13f73045ee
 	github.com/stretchr/testify v1.7.1
@@ -0,0 +1,94 @@
+package block_stm
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+type LogLevel int
+
+const (
+	LogLevelDebug LogLevel = iota
+	LogLevelInfo
+	LogLevelWarn
+	LogLevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogLevelDebug:
+		return "debug"
+	case LogLevelInfo:
+		return "info"
+	case LogLevelWarn:
+		return "warn"
+	case LogLevelError:
+		return "error"
+	}
+	return fmt.Sprintf("level(%d)", int(l))
+}
+
+// Field: a structured key/value of a log entry
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// Logger: leveled, structured logging. Callers check Enabled before building the fields of an entry so logging that
+// is switched off costs next to nothing on the hot path. Implementations must be safe for concurrent use.
+type Logger interface {
+	Enabled(level LogLevel) bool
+	Log(level LogLevel, msg string, fields ...Field)
+}
+
+// nopLogger: the default, logs nothing
+type nopLogger struct{}
+
+func (nopLogger) Enabled(LogLevel) bool { return false }
+
+func (nopLogger) Log(LogLevel, string, ...Field) {}
+
+// textLogger: one line of key=value pairs per entry
+type textLogger struct {
+	mu       sync.Mutex
+	w        io.Writer
+	minLevel LogLevel
+}
+
+// NewTextLogger: logs entries at minLevel and above to w, one line each, for example
+//  level=debug msg="executed task" tx=3 incarnation=0 reads=1 writes=1
+func NewTextLogger(w io.Writer, minLevel LogLevel) Logger {
+	return &textLogger{w: w, minLevel: minLevel}
+}
+
+func (l *textLogger) Enabled(level LogLevel) bool {
+	return level >= l.minLevel
+}
+
+func (l *textLogger) Log(level LogLevel, msg string, fields ...Field) {
+	if !l.Enabled(level) {
+		return
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "level=%v msg=%q", level, msg)
+	for _, f := range fields {
+		if s, ok := f.Value.(string); ok {
+			fmt.Fprintf(&sb, " %v=%q", f.Key, s)
+		} else if err, ok := f.Value.(error); ok {
+			fmt.Fprintf(&sb, " %v=%q", f.Key, err.Error())
+		} else {
+			fmt.Fprintf(&sb, " %v=%v", f.Key, f.Value)
+		}
+	}
+	sb.WriteByte('\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, _ = io.WriteString(l.w, sb.String())
+}
@@ -0,0 +1,89 @@
+package block_stm
+
+import (
+	"bytes"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestTextLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := NewTextLogger(&buf, LogLevelInfo)
+	require.False(t, l.Enabled(LogLevelDebug))
+	require.True(t, l.Enabled(LogLevelWarn))
+
+	l.Log(LogLevelDebug, "not logged", F("tx", 1))
+	l.Log(LogLevelInfo, "exec summary", F("execs", 10), F("name", "a b"), F("err", errors.New("failed")))
+	require.Equal(t, "level=info msg=\"exec summary\" execs=10 name=\"a b\" err=\"failed\"\n", buf.String())
+}
+
+type testLogEntry struct {
+	level  LogLevel
+	msg    string
+	fields []Field
+}
+
+type testCaptureLogger struct {
+	mu       sync.Mutex
+	entries  []testLogEntry
+	disabled bool
+}
+
+func (l *testCaptureLogger) Enabled(LogLevel) bool {
+	return !l.disabled
+}
+
+func (l *testCaptureLogger) Log(level LogLevel, msg string, fields ...Field) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, testLogEntry{level, msg, fields})
+}
+
+func TestExecutorLogger(t *testing.T) {
+	require.False(t, NewExecutor(ExecOptions{}).Options().Logger.Enabled(LogLevelError), "silent by default")
+
+	var l testCaptureLogger
+	e := NewExecutor(ExecOptions{Logger: &l})
+	exec := makeTestConflictTasks(10)
+	for _, execute := range testExecutors(e) {
+		l.entries = nil
+		_, _, _, err := execute(exec, testBaseReadWrite{})
+		require.NoError(t, err)
+
+		var executed int
+		for _, entry := range l.entries {
+			if entry.msg == "executed task" {
+				executed++
+				require.Equal(t, LogLevelDebug, entry.level)
+				require.Equal(t, []string{"tx", "incarnation", "reads", "writes"}, fieldKeys(entry.fields))
+			}
+		}
+		require.GreaterOrEqual(t, executed, len(exec))
+
+		var summary testLogEntry
+		for _, entry := range l.entries {
+			if strings.HasSuffix(entry.msg, "exec summary") {
+				summary = entry
+			}
+		}
+		require.Equal(t, LogLevelInfo, summary.level)
+	}
+
+	// nothing is logged, and so no fields are built, unless the level is enabled
+	l = testCaptureLogger{disabled: true}
+	for _, execute := range testExecutors(e) {
+		_, _, _, err := execute(exec, testBaseReadWrite{})
+		require.NoError(t, err)
+		require.Empty(t, l.entries)
+	}
+}
+
+func fieldKeys(fields []Field) (keys []string) {
+	for _, f := range fields {
+		keys = append(keys, f.Key)
+	}
+	return
+}
@@ -0,0 +1,243 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+
+	blockstm "github.com/paulgoleary/go-block-stm"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var (
+	incarnationBuckets = []float64{1, 2, 3, 5, 10, 20, 50}
+	blockTimeBuckets   = []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	speedupBuckets     = []float64{.5, 1, 1.5, 2, 3, 4, 6, 8, 12, 16, 32}
+)
+
+type metric interface {
+	write(w io.Writer, namespace string)
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %v %v\n# TYPE %v %v\n", name, help, name, kind)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type counter struct {
+	name, help string
+	v          float64
+}
+
+func (c *counter) add(v float64) {
+	c.v += v
+}
+
+func (c *counter) write(w io.Writer, namespace string) {
+	name := namespace + "_" + c.name
+	writeHeader(w, name, c.help, "counter")
+	fmt.Fprintf(w, "%v %v\n", name, formatFloat(c.v))
+}
+
+type gauge struct {
+	name, help string
+	v          float64
+}
+
+func (g *gauge) set(v float64) {
+	g.v = v
+}
+
+func (g *gauge) write(w io.Writer, namespace string) {
+	name := namespace + "_" + g.name
+	writeHeader(w, name, g.help, "gauge")
+	fmt.Fprintf(w, "%v %v\n", name, formatFloat(g.v))
+}
+
+// histogram: counts are per bucket, they are only made cumulative when written
+type histogram struct {
+	name, help string
+	buckets    []float64
+	counts     []uint64
+	sum        float64
+	count      uint64
+}
+
+func newHistogram(name, help string, buckets []float64) *histogram {
+	return &histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, le := range h.buckets {
+		if v <= le {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer, namespace string) {
+	name := namespace + "_" + h.name
+	writeHeader(w, name, h.help, "histogram")
+	var cumulative uint64
+	for i, le := range h.buckets {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%v_bucket{le=\"%v\"} %v\n", name, formatFloat(le), cumulative)
+	}
+	fmt.Fprintf(w, "%v_bucket{le=\"+Inf\"} %v\n", name, h.count)
+	fmt.Fprintf(w, "%v_sum %v\n", name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%v_count %v\n", name, h.count)
+}
+
+// Metrics: aggregates the executions of blocks into counters, gauges and histograms in the Prometheus text
+//  exposition format. Attach it to an executor with Hooks and serve it over HTTP, for example
+//   m := metrics.New("block_stm")
+//   e := blockstm.NewExecutor(blockstm.ExecOptions{Hooks: m.Hooks()})
+//   http.Handle("/metrics", m)
+type Metrics struct {
+	mu        sync.Mutex
+	namespace string
+
+	blocks             *counter
+	blockErrors        *counter
+	transactions       *counter
+	executions         *counter
+	successes          *counter
+	failures           *counter
+	aborts             *counter
+	validations        *counter
+	validationFailures *counter
+
+	incarnations *histogram
+	blockTime    *histogram
+	speedup      *histogram
+
+	keys        *gauge
+	lastSpeedup *gauge
+	utilization *gauge
+	workers     *gauge
+
+	all []metric
+}
+
+// New: metrics named with the namespace as prefix, for example block_stm_executions_total
+func New(namespace string) *Metrics {
+	m := &Metrics{
+		namespace: namespace,
+
+		blocks:             &counter{name: "blocks_total", help: "Blocks executed, including failed and cancelled ones."},
+		blockErrors:        &counter{name: "block_errors_total", help: "Blocks whose execution returned an error."},
+		transactions:       &counter{name: "transactions_total", help: "Transactions in executed blocks."},
+		executions:         &counter{name: "executions_total", help: "Incarnations executed."},
+		successes:          &counter{name: "execution_successes_total", help: "Incarnations that executed successfully."},
+		failures:           &counter{name: "execution_failures_total", help: "Incarnations whose task failed."},
+		aborts:             &counter{name: "execution_aborts_total", help: "Incarnations aborted on a dependency."},
+		validations:        &counter{name: "validations_total", help: "Read sets validated."},
+		validationFailures: &counter{name: "validation_failures_total", help: "Read sets that failed validation."},
+
+		incarnations: newHistogram("incarnations_per_transaction", "Incarnations each transaction needed.", incarnationBuckets),
+		blockTime:    newHistogram("block_duration_seconds", "Wall time of executing a block.", blockTimeBuckets),
+		speedup:      newHistogram("block_speedup", "Estimated speedup of executing a block over serial execution.", speedupBuckets),
+
+		keys:        &gauge{name: "mvhashmap_keys", help: "Keys in the MVHashMap of the last block."},
+		lastSpeedup: &gauge{name: "last_block_speedup", help: "Estimated speedup of the last block over serial execution."},
+		utilization: &gauge{name: "last_block_worker_utilization", help: "Fraction of worker time spent on tasks in the last block."},
+		workers:     &gauge{name: "workers", help: "Workers executing the last block."},
+	}
+	m.all = []metric{m.blocks, m.blockErrors, m.transactions, m.executions, m.successes, m.failures, m.aborts,
+		m.validations, m.validationFailures, m.incarnations, m.blockTime, m.speedup, m.keys, m.lastSpeedup,
+		m.utilization, m.workers}
+	return m
+}
+
+// Hooks: the executor hooks that feed these metrics. Only OnBlock is set, so it can be combined with other hooks.
+func (m *Metrics) Hooks() blockstm.ExecHooks {
+	return blockstm.ExecHooks{OnBlock: m.Observe}
+}
+
+// Speedup: estimate of the speedup of a block over serial execution - the average time of an execution for every
+//  transaction, over the wall time. Zero if nothing was executed.
+func Speedup(stats blockstm.ExecStats) float64 {
+	if stats.Executions == 0 || stats.WallTime <= 0 {
+		return 0
+	}
+	serialTime := stats.ExecTime.Seconds() * float64(stats.NumTxns) / float64(stats.Executions)
+	return serialTime / stats.WallTime.Seconds()
+}
+
+// Observe: adds the execution of a block
+func (m *Metrics) Observe(ev blockstm.BlockEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := ev.Stats
+	m.blocks.add(1)
+	if ev.Err != nil {
+		m.blockErrors.add(1)
+	}
+	m.transactions.add(float64(s.NumTxns))
+	m.executions.add(float64(s.Executions))
+	m.successes.add(float64(s.Successes))
+	m.failures.add(float64(s.Failures))
+	m.aborts.add(float64(s.Aborts))
+	m.validations.add(float64(s.Validations))
+	m.validationFailures.add(float64(s.ValidationFailures))
+
+	// not known if the block was cancelled
+	for _, inc := range s.Incarnations {
+		m.incarnations.observe(float64(inc))
+	}
+	m.blockTime.observe(s.WallTime.Seconds())
+	if speedup := Speedup(s); speedup > 0 {
+		m.speedup.observe(speedup)
+		m.lastSpeedup.set(speedup)
+	}
+
+	m.keys.set(float64(ev.NumKeys))
+	m.utilization.set(s.Utilization)
+	m.workers.set(float64(s.Workers))
+}
+
+// WriteTo: writes all the metrics in the Prometheus text exposition format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+
+	m.mu.Lock()
+	for _, mt := range m.all {
+		mt.write(bw, m.namespace)
+	}
+	m.mu.Unlock()
+
+	err := bw.Flush()
+	return cw.n, err
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", contentType)
+	_, _ = m.WriteTo(w)
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	blockstm "github.com/paulgoleary/go-block-stm"
+)
+
+// testIncrementTask: every transaction increments the same key so they all conflict
+type testIncrementTask struct{}
+
+func (testIncrementTask) Execute(rw blockstm.BaseReadWrite) error {
+	v, err := rw.Read([]byte("counter"))
+	if err != nil {
+		return err
+	}
+	time.Sleep(100 * time.Microsecond)
+	var cnt byte
+	if len(v) > 0 {
+		cnt = v[0]
+	}
+	return rw.Write([]byte("counter"), []byte{cnt + 1})
+}
+
+type testBaseReadWrite struct{}
+
+func (testBaseReadWrite) Read([]byte) ([]byte, error) { return nil, nil }
+
+func (testBaseReadWrite) Write([]byte, []byte) error { return nil }
+
+func TestObserve(t *testing.T) {
+	m := New("test")
+	m.Observe(blockstm.BlockEvent{
+		Stats: blockstm.ExecStats{NumTxns: 3, Workers: 2, Executions: 4, Successes: 3, Aborts: 1, Validations: 5,
+			ValidationFailures: 1, Incarnations: []int{1, 2, 1}, WallTime: 2 * time.Millisecond,
+			ExecTime: 4 * time.Millisecond, Utilization: 0.75},
+		NumKeys: 7,
+	})
+	m.Observe(blockstm.BlockEvent{Stats: blockstm.ExecStats{NumTxns: 1}, Err: errors.New("failed")})
+
+	var buf bytes.Buffer
+	n, err := m.WriteTo(&buf)
+	require.NoError(t, err)
+	require.Equal(t, int64(buf.Len()), n)
+	out := buf.String()
+
+	for _, line := range []string{
+		"# HELP test_blocks_total Blocks executed, including failed and cancelled ones.",
+		"# TYPE test_blocks_total counter",
+		"test_blocks_total 2",
+		"test_block_errors_total 1",
+		"test_transactions_total 4",
+		"test_executions_total 4",
+		"test_execution_aborts_total 1",
+		"test_validation_failures_total 1",
+		"# TYPE test_incarnations_per_transaction histogram",
+		`test_incarnations_per_transaction_bucket{le="1"} 2`,
+		`test_incarnations_per_transaction_bucket{le="2"} 3`,
+		`test_incarnations_per_transaction_bucket{le="+Inf"} 3`,
+		"test_incarnations_per_transaction_sum 4",
+		"test_incarnations_per_transaction_count 3",
+		"test_block_duration_seconds_count 2",
+		// 3 transactions at 1ms each in 2ms
+		"test_block_speedup_count 1",
+		"test_last_block_speedup 1.5",
+		"# TYPE test_mvhashmap_keys gauge",
+		"test_mvhashmap_keys 0",
+		"test_workers 0",
+	} {
+		require.Contains(t, out, line+"\n")
+	}
+}
+
+func TestExecutorMetrics(t *testing.T) {
+	const numTxns = 20
+	tasks := make([]blockstm.ExecTask, numTxns)
+	for i := range tasks {
+		tasks[i] = testIncrementTask{}
+	}
+
+	m := New("block_stm")
+	e := blockstm.NewExecutor(blockstm.ExecOptions{Workers: 4, Hooks: m.Hooks()})
+	_, _, stats, err := e.ExecuteParallel(tasks, testBaseReadWrite{})
+	require.NoError(t, err)
+	_, _, _, err = e.ExecuteScheduled(tasks, testBaseReadWrite{})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	require.Equal(t, contentType, rec.Header().Get("Content-Type"))
+	out := rec.Body.String()
+
+	require.Contains(t, out, "block_stm_blocks_total 2\n")
+	require.Contains(t, out, fmt.Sprintf("block_stm_transactions_total %v\n", 2*numTxns))
+	require.Contains(t, out, fmt.Sprintf("block_stm_incarnations_per_transaction_count %v\n", 2*numTxns))
+	require.Contains(t, out, "block_stm_mvhashmap_keys 1\n")
+	require.Contains(t, out, "block_stm_workers 4\n")
+	require.GreaterOrEqual(t, stats.Executions, int64(numTxns))
+
+	// every sample line is a name, optional labels and a value
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			require.Len(t, strings.Fields(line), 2, line)
+		}
+	}
+}
@@ -1,12 +1,12 @@
 package block_stm
 
 import (
-	"encoding/base64"
+	"bytes"
 	"errors"
 	"fmt"
+	"math"
+	"sort"
 	"sync"
-
-	"github.com/emirpasic/gods/maps/treemap"
 )
 
 const FlagDone = 0
@@ -17,121 +17,174 @@ var (
 	ErrInvalidKeyCellPath = errors.New("invalid key cell path, must already exist")
 )
 
+// defaultMVHashMapShards: a power of two, well above the number of workers so they rarely contend for a shard
+const defaultMVHashMapShards = 64
+
 type MVHashMap struct {
+	shards []mvShard
+	mask   uint32
+}
+
+// mvShard: keys are spread over shards by hash so that adding a new key only locks out the keys of its own shard
+type mvShard struct {
 	rw sync.RWMutex
-	m  map[string]*TxnIndexCells // TODO: might want a more efficient key representation
+	// keys are the raw bytes of the path - string(k) lookups don't allocate
+	m map[string]*TxnIndexCells
+	// pad to a cache line so neighbouring shards don't contend
+	_ [32]byte
 }
 
 func MakeMVHashMap() *MVHashMap {
-	return &MVHashMap{
-		rw: sync.RWMutex{},
-		m:  make(map[string]*TxnIndexCells),
+	return makeMVHashMapShards(defaultMVHashMapShards)
+}
+
+// makeMVHashMapShards: numShards must be a power of two, 1 has every key behind the same lock
+func makeMVHashMapShards(numShards int) *MVHashMap {
+	mv := &MVHashMap{
+		shards: make([]mvShard, numShards),
+		mask:   uint32(numShards - 1),
 	}
+	for i := range mv.shards {
+		mv.shards[i].m = make(map[string]*TxnIndexCells)
+	}
+	return mv
 }
 
+// shard: FNV-1a of the key
+func (mv *MVHashMap) shard(k []byte) *mvShard {
+	h := uint32(2166136261)
+	for _, b := range k {
+		h ^= uint32(b)
+		h *= 16777619
+	}
+	return &mv.shards[h&mv.mask]
+}
+
+// WriteCell: a write of a transaction to a location. Never changed once in a TxnIndexCells, it is replaced instead.
 type WriteCell struct {
 	flag        uint
 	incarnation int
 	data        []byte
 }
 
-// Structure of tm (treemap):
-// Key:     TxnIndex
-// Value:   &WriteCell
-// example: map[10:&{0 1 [53 48 101 114 58 49 49 101 114 58 53 101 114]}]
-// map[TxnIndex:&{flag incarnation data}]
-type TxnIndexCells struct {
-	rw sync.RWMutex
-	tm *treemap.Map
-}
-
 type Version struct {
 	TxnIndex    int
 	Incarnation int
 }
 
-func (mv *MVHashMap) getKeyCells(k []byte, fNoKey func(kenc string) *TxnIndexCells) (cells *TxnIndexCells) {
-	kenc := base64.StdEncoding.EncodeToString(k)
+func (mv *MVHashMap) getKeyCells(k []byte, fNoKey func(s *mvShard, k []byte) *TxnIndexCells) (cells *TxnIndexCells) {
+	s := mv.shard(k)
 	var ok bool
-	mv.rw.RLock()
-	cells, ok = mv.m[kenc]
-	mv.rw.RUnlock()
+	s.rw.RLock()
+	cells, ok = s.m[string(k)]
+	s.rw.RUnlock()
 	if !ok {
-		cells = fNoKey(kenc)
+		cells = fNoKey(s, k)
 	}
 	return
 }
 
-// arguments:   memory location, Version, data
-// returns:     mvReadResult
-func (mv *MVHashMap) Write(k []byte, v Version, data []byte) {
+// NumKeys: the number of distinct locations written
+func (mv *MVHashMap) NumKeys() (n int) {
+	for i := range mv.shards {
+		s := &mv.shards[i]
+		s.rw.RLock()
+		n += len(s.m)
+		s.rw.RUnlock()
+	}
+	return
+}
 
-	cells := mv.getKeyCells(k, func(kenc string) (cells *TxnIndexCells) {
-		n := &TxnIndexCells{
-			rw: sync.RWMutex{},
-			tm: treemap.NewWithIntComparator(),
+// Reset: empties the map so it can be used for the next block, reusing what was allocated for this one. Must not be
+//  called while anything else is using the map.
+func (mv *MVHashMap) Reset() {
+	for i := range mv.shards {
+		s := &mv.shards[i]
+		s.rw.Lock()
+		for k, cells := range s.m {
+			cells.release()
+			delete(s.m, k)
 		}
+		s.rw.Unlock()
+	}
+}
+
+// arguments:   memory location, Version, data
+// returns:     ErrLowerIncarnation if a later incarnation of the transaction has already written the location
+func (mv *MVHashMap) Write(k []byte, v Version, data []byte) error {
+	_, err := mv.write(k, v, data, FlagDone)
+	return err
+}
+
+// write: as Write but with the flag of the written cell. Returns true if the cell was previously an estimate.
+func (mv *MVHashMap) write(k []byte, v Version, data []byte, flag uint) (wasEstimate bool, err error) {
+
+	cells := mv.getKeyCells(k, func(s *mvShard, k []byte) (cells *TxnIndexCells) {
+		n := makeTxnIndexCells()
 		var ok bool
-		mv.rw.Lock()
-		if cells, ok = mv.m[kenc]; !ok {
-			mv.m[kenc] = n
+		s.rw.Lock()
+		if cells, ok = s.m[string(k)]; !ok {
+			s.m[string(k)] = n
 			cells = n
 		}
-		mv.rw.Unlock()
+		s.rw.Unlock()
 		return
 	})
 
-	// TODO: could probably have a scheme where this only generally requires a read lock since any given transaction transaction
-	//  should only have one incarnation executing at a time...
-	cells.rw.Lock()
-	defer cells.rw.Unlock()
-	ci, ok := cells.tm.Get(v.TxnIndex)
-	if ok {
-		if ci.(*WriteCell).incarnation >= v.Incarnation {
-			// ErrLowerIncarnation
-			panic(fmt.Errorf("existing transaction value does not have lower incarnation: %v, %v",
-				base64.StdEncoding.EncodeToString(k), v.TxnIndex))
-		} else if ci.(*WriteCell).flag == FlagEstimate {
-			println("marking previous estimate as done tx", v.TxnIndex, v.Incarnation)
+	cells.mu.Lock()
+	defer cells.mu.Unlock()
+	wc := makeWriteCell(flag, v.Incarnation, data)
+	var prev [maxVersionLevel]*versionNode
+	if n := cells.find(v.TxnIndex, &prev); n != nil {
+		// the same incarnation can write a location more than once
+		c := n.cell.load()
+		if c.incarnation > v.Incarnation {
+			return false, fmt.Errorf("%w: %q, %v", ErrLowerIncarnation, k, v.TxnIndex)
 		}
-		ci.(*WriteCell).flag = FlagDone
-		ci.(*WriteCell).incarnation = v.Incarnation
-		ci.(*WriteCell).data = data
+		wasEstimate = c.flag == FlagEstimate
+		n.cell.store(wc)
 	} else {
-		cells.tm.Put(v.TxnIndex, &WriteCell{
-			flag:        FlagDone,
-			incarnation: v.Incarnation,
-			data:        data,
-		})
+		cells.insert(v.TxnIndex, wc, &prev)
 	}
 
 	return
 }
 
-func (mv *MVHashMap) MarkEstimate(k []byte, txIdx int) {
+func noKeyCells(_ *mvShard, _ []byte) *TxnIndexCells {
+	return nil
+}
 
-	cells := mv.getKeyCells(k, func(_ string) *TxnIndexCells {
-		// ErrInvalidKeyCellPath
-		panic(fmt.Errorf("path must already exist"))
-	})
+// MarkEstimate: returns ErrInvalidKeyCellPath if the transaction has not written the location
+func (mv *MVHashMap) MarkEstimate(k []byte, txIdx int) error {
 
-	cells.rw.RLock()
-	if ci, ok := cells.tm.Get(txIdx); !ok {
-		panic("should not happen - cell should be present for path")
-	} else {
-		ci.(*WriteCell).flag = FlagEstimate
+	cells := mv.getKeyCells(k, noKeyCells)
+	if cells == nil {
+		return fmt.Errorf("%w: %q", ErrInvalidKeyCellPath, k)
+	}
+
+	cells.mu.Lock()
+	defer cells.mu.Unlock()
+	n := cells.get(txIdx)
+	if n == nil {
+		return fmt.Errorf("%w: %q, %v", ErrInvalidKeyCellPath, k, txIdx)
 	}
-	cells.rw.RUnlock()
+	c := n.cell.load()
+	n.cell.store(makeWriteCell(FlagEstimate, c.incarnation, c.data))
+	return nil
 }
 
-func (mv *MVHashMap) Delete(k []byte, txIdx int) {
-	cells := mv.getKeyCells(k, func(_ string) *TxnIndexCells {
-		panic(fmt.Errorf("path must already exist"))
-	})
+// Delete: returns ErrInvalidKeyCellPath if the location has never been written. Deleting a transaction that has not
+//  written an existing location is a no-op.
+func (mv *MVHashMap) Delete(k []byte, txIdx int) error {
+	cells := mv.getKeyCells(k, noKeyCells)
+	if cells == nil {
+		return fmt.Errorf("%w: %q", ErrInvalidKeyCellPath, k)
+	}
 
-	cells.rw.Lock()
-	defer cells.rw.Unlock()
-	cells.tm.Remove(txIdx)
+	cells.mu.Lock()
+	defer cells.mu.Unlock()
+	cells.remove(txIdx)
+	return nil
 }
 
 // mvReadResultDone:         read result for the current tx (depIdx != -1 , incarnation != -1)
@@ -170,30 +223,21 @@ func (mv *MVHashMap) Read(k []byte, txIdx int) (res mvReadResult) {
 	res.depIdx = -1
 	res.incarnation = -1
 
-	cells := mv.getKeyCells(k, func(_ string) *TxnIndexCells {
-		return nil
-	})
+	cells := mv.getKeyCells(k, noKeyCells)
 	if cells == nil {
 		return
 	}
 
-	cells.rw.RLock()
-	defer cells.rw.RUnlock()
-
-	// fk:   depIdx
-	// fv:   flag, incarnation, data (example: &{1 1 [53 48 101 114 58 49 49 101 114 58 53 101 114]})
-	// Floor key is defined as the largest key that is smaller than or equal to the given key
-	// this reads from the treemap, key (fk) and value (fv) of transaction with largest index than txIdx,
-	// returns nil if not found
-	if fk, fv := cells.tm.Floor(txIdx - 1); fk != nil && fv != nil {
-		c := fv.(*WriteCell)
+	// the write of the highest transaction below txIdx, without locking
+	if n := cells.floor(txIdx - 1); n != nil {
+		c := n.cell.load()
 		switch c.flag {
 		case FlagEstimate:
-			res.depIdx = fk.(int)
+			res.depIdx = n.txIdx
 			res.value = c.data
 		case FlagDone:
 			{
-				res.depIdx = fk.(int)
+				res.depIdx = n.txIdx
 				res.incarnation = c.incarnation
 				res.value = c.data
 			}
@@ -204,3 +248,28 @@ func (mv *MVHashMap) Read(k []byte, txIdx int) (res mvReadResult) {
 
 	return
 }
+
+// StateDiff: returns, for every path, the write of the highest transaction index. Once all transactions are executed
+//  and validated this is the final state of the block. Ordered by path so the result is deterministic.
+func (mv *MVHashMap) StateDiff() (diff StateDiff) {
+	for i := range mv.shards {
+		s := &mv.shards[i]
+		s.rw.RLock()
+		for k, cells := range s.m {
+			if n := cells.floor(math.MaxInt); n != nil {
+				c := n.cell.load()
+				diff = append(diff, WriteDescriptor{
+					Path: []byte(k),
+					V:    Version{TxnIndex: n.txIdx, Incarnation: c.incarnation},
+					Val:  c.data,
+				})
+			}
+		}
+		s.rw.RUnlock()
+	}
+
+	sort.Slice(diff, func(i, j int) bool {
+		return bytes.Compare(diff[i].Path, diff[j].Path) < 0
+	})
+	return
+}
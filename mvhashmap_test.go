@@ -3,6 +3,7 @@ package block_stm
 import (
 	"fmt"
 	"math/rand"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -61,21 +62,217 @@ func BenchmarkReadTimeSameLocationDifferentTxIdx(b *testing.B) {
 	readRes = append(readRes, res)
 }
 
+// benchmarkMVHashMapWorkers: b.N calls of op split over numWorkers goroutines, for a map with one shard - every
+//  new key behind the same lock - and the default sharding. For example
+//  go test -run XXX -bench BenchmarkMVHashMapWriteNewKeys -cpu 16
+func benchmarkMVHashMapWorkers(b *testing.B, prepare func(mvh *MVHashMap, keys [][]byte), op func(mvh *MVHashMap, keys [][]byte, i int)) {
+	for _, numShards := range []int{1, defaultMVHashMapShards} {
+		for _, numWorkers := range []int{1, 2, 4, 8, 16} {
+			b.Run(fmt.Sprintf("shards-%v/workers-%v", numShards, numWorkers), func(b *testing.B) {
+				keys := make([][]byte, b.N)
+				for i := range keys {
+					keys[i] = []byte(fmt.Sprintf("/foo/%v", i))
+				}
+				mvh := makeMVHashMapShards(numShards)
+				if prepare != nil {
+					prepare(mvh, keys)
+				}
+				var wg sync.WaitGroup
+				b.ResetTimer()
+				for w := 0; w < numWorkers; w++ {
+					wg.Add(1)
+					go func(w int) {
+						defer wg.Done()
+						for i := w; i < b.N; i += numWorkers {
+							op(mvh, keys, i)
+						}
+					}(w)
+				}
+				wg.Wait()
+			})
+		}
+	}
+}
+
+// every write is to a new key, the worst case for a single lock
+func BenchmarkMVHashMapWriteNewKeys(b *testing.B) {
+	benchmarkMVHashMapWorkers(b, nil, func(mvh *MVHashMap, keys [][]byte, i int) {
+		_ = mvh.Write(keys[i], Version{i, 1}, keys[i])
+	})
+}
+
+// reads and writes of existing keys, as when transactions execute again
+func BenchmarkMVHashMapReadWriteExistingKeys(b *testing.B) {
+	const numKeys = 1000
+	benchmarkMVHashMapWorkers(b, func(mvh *MVHashMap, keys [][]byte) {
+		for i := 0; i < numKeys && i < len(keys); i++ {
+			_ = mvh.Write(keys[i], Version{0, 0}, keys[i])
+		}
+	}, func(mvh *MVHashMap, keys [][]byte, i int) {
+		k := keys[i%numKeys]
+		if i%4 == 0 {
+			_ = mvh.Write(k, Version{1 + i%100, i}, k)
+		} else {
+			mvh.Read(k, 1+i%100)
+		}
+	})
+}
+
+// a hot key - like the total supply of a token - read by every tx of a block while some of them write it
+func BenchmarkMVHashMapHotKey(b *testing.B) {
+	const numTxns = 1000
+	hot := []byte("/token/supply")
+	benchmarkMVHashMapWorkers(b, func(mvh *MVHashMap, _ [][]byte) {
+		for i := 0; i < numTxns; i += 2 {
+			_ = mvh.Write(hot, Version{i, 0}, hot)
+		}
+	}, func(mvh *MVHashMap, _ [][]byte, i int) {
+		txIdx := i % numTxns
+		if i%10 == 0 {
+			_ = mvh.Write(hot, Version{txIdx, i}, hot)
+		} else {
+			mvh.Read(hot, txIdx)
+		}
+	})
+}
+
+func TestMVHashMapShards(t *testing.T) {
+	// concurrent writes to many keys end up the same however they're sharded
+	var diffs []StateDiff
+	for _, numShards := range []int{1, 4, defaultMVHashMapShards} {
+		mvh := makeMVHashMapShards(numShards)
+		// require can't fail the test from the workers so their errors are checked once they're done
+		errs := make([]error, 8)
+		var wg sync.WaitGroup
+		for w := range errs {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < 1000; i++ {
+					k := []byte(fmt.Sprint(i % 300))
+					if errs[w] = mvh.Write(k, Version{w, i}, valueFor(w, i)); errs[w] != nil {
+						return
+					}
+					mvh.Read(k, 8)
+				}
+			}(w)
+		}
+		wg.Wait()
+		for _, err := range errs {
+			require.NoError(t, err)
+		}
+		require.Equal(t, 300, mvh.NumKeys())
+		diffs = append(diffs, mvh.StateDiff())
+	}
+	require.Len(t, diffs[0], 300)
+	require.Equal(t, diffs[0], diffs[1])
+	require.Equal(t, diffs[0], diffs[2])
+}
+
+func TestReadAllocs(t *testing.T) {
+	mvh := MakeMVHashMap()
+	ap1, ap2 := []byte("/foo/a"), []byte("/foo/b")
+	require.NoError(t, mvh.Write(ap1, Version{1, 1}, valueFor(1, 1)))
+
+	require.NoError(t, mvh.Write(ap1, Version{1000, 1}, valueFor(1000, 1)))
+
+	// looking up keys and their versions doesn't allocate
+	require.Zero(t, testing.AllocsPerRun(100, func() { mvh.Read(ap1, 2) }))
+	require.Zero(t, testing.AllocsPerRun(100, func() { mvh.Read(ap1, 2000) }))
+	require.Zero(t, testing.AllocsPerRun(100, func() { mvh.Read(ap2, 2) }))
+
+	// nor do repeated reads or reads of own writes during execution
+	ev := ExecVersionView{ver: Version{3, 0}, mvh: mvh}
+	ev.ensureReadMap()
+	ev.ensureWriteMap()
+	_, err := ev.Read(ap1)
+	require.NoError(t, err)
+	require.NoError(t, ev.Write(ap2, valueFor(3, 0)))
+	require.Zero(t, testing.AllocsPerRun(100, func() { _, _ = ev.Read(ap1) }))
+	require.Zero(t, testing.AllocsPerRun(100, func() { _, _ = ev.Read(ap2) }))
+}
+
+func TestMVHashMapReset(t *testing.T) {
+	mvh := MakeMVHashMap()
+	write := func(inc int) {
+		for i := 0; i < 100; i++ {
+			k := []byte(fmt.Sprint(i % 30))
+			require.NoError(t, mvh.Write(k, Version{i, inc}, valueFor(i, inc)))
+			if i%7 == 0 {
+				require.NoError(t, mvh.MarkEstimate(k, i))
+			}
+		}
+	}
+	write(0)
+	require.Equal(t, 30, mvh.NumKeys())
+	diff := mvh.StateDiff()
+
+	mvh.Reset()
+	require.Equal(t, 0, mvh.NumKeys())
+	require.Empty(t, mvh.StateDiff())
+	require.Equal(t, mvReadResultNone, mvh.Read([]byte("1"), 100).status())
+	// values handed out before are untouched
+	require.Equal(t, valueFor(99, 0), diff[len(diff)-1].Val)
+
+	// the next block sees none of the last one
+	write(1)
+	fresh := MakeMVHashMap()
+	mvh, fresh = fresh, mvh
+	write(1)
+	require.Equal(t, mvh.StateDiff(), fresh.StateDiff())
+	for i := 0; i < 30; i++ {
+		k := []byte(fmt.Sprint(i))
+		require.Equal(t, mvh.Read(k, 50), fresh.Read(k, 50))
+	}
+}
+
+// a block of writes into a new map each time, or the same one Reset in between
+func BenchmarkMVHashMapReset(b *testing.B) {
+	keys := make([][]byte, 1000)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("/foo/%v", i))
+	}
+	writeBlock := func(mvh *MVHashMap) {
+		for txIdx := 0; txIdx < 10; txIdx++ {
+			for _, k := range keys {
+				_ = mvh.Write(k, Version{txIdx, 0}, k)
+			}
+		}
+	}
+	b.Run("make", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			writeBlock(MakeMVHashMap())
+		}
+	})
+	b.Run("reset", func(b *testing.B) {
+		b.ReportAllocs()
+		mvh := MakeMVHashMap()
+		for i := 0; i < b.N; i++ {
+			writeBlock(mvh)
+			mvh.Reset()
+		}
+	})
+}
+
 // go test -run TestLowerIncarnation -v
-// this will panic
-// PSP - handel panic
 func TestLowerIncarnation(t *testing.T) {
 	ap1 := []byte("/foo/b")
 
 	mvh := MakeMVHashMap()
 
-	mvh.Write(ap1, Version{0, 2}, valueFor(0, 2))
+	require.NoError(t, mvh.Write(ap1, Version{0, 2}, valueFor(0, 2)))
 	mvh.Read(ap1, 0)
-	mvh.Write(ap1, Version{1, 2}, valueFor(1, 2))
-	mvh.Write(ap1, Version{0, 5}, valueFor(0, 5))
-	mvh.Write(ap1, Version{1, 5}, valueFor(1, 5))
-	// will fail (panic) as Version{0 4} has lower incarnation than Version{0 5}
-	mvh.Write(ap1, Version{0, 4}, valueFor(0, 4))
+	require.NoError(t, mvh.Write(ap1, Version{1, 2}, valueFor(1, 2)))
+	require.NoError(t, mvh.Write(ap1, Version{0, 5}, valueFor(0, 5)))
+	require.NoError(t, mvh.Write(ap1, Version{1, 5}, valueFor(1, 5)))
+	// will fail as Version{0 4} has lower incarnation than Version{0 5}
+	require.ErrorIs(t, mvh.Write(ap1, Version{0, 4}, valueFor(0, 4)), ErrLowerIncarnation)
+
+	// and the existing value is unchanged
+	res := mvh.Read(ap1, 1)
+	require.Equal(t, 5, res.incarnation)
+	require.Equal(t, valueFor(0, 5), res.value)
 }
 
 func TestMarkEstimate(t *testing.T) {
@@ -83,9 +280,22 @@ func TestMarkEstimate(t *testing.T) {
 
 	mvh := MakeMVHashMap()
 
-	mvh.Write(ap1, Version{7, 2}, valueFor(7, 2))
-	mvh.MarkEstimate(ap1, 7)
-	mvh.Write(ap1, Version{7, 4}, valueFor(7, 4))
+	require.NoError(t, mvh.Write(ap1, Version{7, 2}, valueFor(7, 2)))
+	require.NoError(t, mvh.MarkEstimate(ap1, 7))
+	require.NoError(t, mvh.Write(ap1, Version{7, 4}, valueFor(7, 4)))
+}
+
+func TestInvalidKeyCellPath(t *testing.T) {
+	ap1 := []byte("/foo/b")
+	ap2 := []byte("/foo/c")
+
+	mvh := MakeMVHashMap()
+	require.NoError(t, mvh.Write(ap1, Version{7, 0}, valueFor(7, 0)))
+
+	require.ErrorIs(t, mvh.MarkEstimate(ap2, 7), ErrInvalidKeyCellPath)
+	require.ErrorIs(t, mvh.MarkEstimate(ap1, 8), ErrInvalidKeyCellPath, "path exists but not for the tx")
+	require.ErrorIs(t, mvh.Delete(ap2, 7), ErrInvalidKeyCellPath)
+	require.NoError(t, mvh.Delete(ap1, 8))
 }
 
 func TestTimeComplexity(t *testing.T) {
@@ -258,8 +468,8 @@ func TestMVHashMapBasics(t *testing.T) {
 	res = mvh.Read(ap3, 30)
 	require.Equal(t, -1, res.depIdx)
 
-	// No-op delete at ap2 - doesn't panic because ap2 does exist
-	mvh.Delete(ap2, 11)
+	// No-op delete at ap2 - not an error because ap2 does exist
+	require.NoError(t, mvh.Delete(ap2, 11))
 
 	// Read entry by txn 10 at ap2.
 	res = mvh.Read(ap2, 15)
@@ -269,3 +479,24 @@ func TestMVHashMapBasics(t *testing.T) {
 
 	fmt.Println("\nmvh:", mvh)
 }
+
+func TestMVHashMapStateDiff(t *testing.T) {
+	ap1 := []byte("/foo/b")
+	ap2 := []byte("/foo/a")
+	ap3 := []byte("/foo/c")
+
+	mvh := MakeMVHashMap()
+
+	require.NoError(t, mvh.Write(ap1, Version{3, 1}, valueFor(3, 1)))
+	require.NoError(t, mvh.Write(ap1, Version{7, 0}, valueFor(7, 0)))
+	require.NoError(t, mvh.Write(ap1, Version{5, 2}, valueFor(5, 2)))
+	require.NoError(t, mvh.Write(ap2, Version{1, 0}, valueFor(1, 0)))
+	require.NoError(t, mvh.Write(ap3, Version{2, 0}, valueFor(2, 0)))
+	require.NoError(t, mvh.Delete(ap3, 2))
+
+	diff := mvh.StateDiff()
+	require.Equal(t, StateDiff{
+		{Path: ap2, V: Version{1, 0}, Val: valueFor(1, 0)},
+		{Path: ap1, V: Version{7, 0}, Val: valueFor(7, 0)},
+	}, diff, "highest tx per path, ordered by path, deleted paths omitted")
+}
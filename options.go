@@ -0,0 +1,212 @@
+package block_stm
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultNumWorkers      = 10
+	defaultValidationBatch = 5
+)
+
+var ErrMaxIncarnations = errors.New("transaction exceeded the maximum number of incarnations")
+
+// ErrorPolicy: what happens when a task fails with an error of its own, as opposed to aborting on a dependency
+type ErrorPolicy int
+
+const (
+	// ErrorPolicyFailBlock fails the block with a *TxnError for the lowest failed transaction. Since an incarnation can
+	// fail speculatively this is only decided once execution of the block is complete.
+	ErrorPolicyFailBlock ErrorPolicy = iota
+	// ErrorPolicyRecordFailure treats the failure as the outcome of the transaction - for example a reverted EVM
+	// transaction. Its reads are kept so it is validated like any other, its writes are discarded and the error is
+	// available from TxnInputOutput.Err.
+	ErrorPolicyRecordFailure
+)
+
+// TxnError: the error of a failed transaction with the incarnation that produced it
+type TxnError struct {
+	Version Version
+	Err     error
+}
+
+func (e *TxnError) Error() string {
+	return fmt.Sprintf("tx %v.%v failed: %v", e.Version.TxnIndex, e.Version.Incarnation, e.Err)
+}
+
+func (e *TxnError) Unwrap() error {
+	return e.Err
+}
+
+// PanicError: a panic recovered while executing a transaction, for example from a bad contract or adapter bug
+type PanicError struct {
+	Version Version
+	Value   interface{}
+	Stack   []byte
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic executing tx %v.%v: %v", e.Version.TxnIndex, e.Version.Incarnation, e.Value)
+}
+
+// ExecEvent: the outcome of executing one incarnation
+type ExecEvent struct {
+	Version Version
+	// Aborted is set if the incarnation read an estimate of DepIdx and has to execute again
+	Aborted bool
+	DepIdx  int
+	DepPath []byte
+	// Err is set if the task itself failed
+	Err error
+}
+
+// ValidateEvent: the outcome of validating the read set of one incarnation
+type ValidateEvent struct {
+	Version Version
+	Valid   bool
+	// Path is the first read that is no longer valid
+	Path []byte
+}
+
+// BlockEvent: the outcome of executing a block, including one that failed or was cancelled
+type BlockEvent struct {
+	Stats ExecStats
+	// NumKeys is the number of keys in the MVHashMap once execution stopped
+	NumKeys int
+	Err     error
+}
+
+// ExecHooks: optional callbacks as a block is executed. They may be called concurrently from worker goroutines so
+// must be safe for concurrent use, and they are on the hot path so should return quickly. OnBlock is called once,
+// when ExecuteParallel or ExecuteScheduled returns.
+type ExecHooks struct {
+	OnExecute  func(ev ExecEvent)
+	OnValidate func(ev ValidateEvent)
+	OnBlock    func(ev BlockEvent)
+}
+
+// ExecOptions: configuration of an Executor. Zero values are replaced by defaults.
+type ExecOptions struct {
+	// Workers is the number of goroutines executing tasks
+	Workers int
+	// ValidationBatch is the number of validations ExecuteParallel does for each execution result while there are
+	// still tasks executing. Once all tasks are executed everything pending is validated.
+	ValidationBatch int
+	// MaxIncarnations, if set, fails the block with ErrMaxIncarnations once any transaction would need to execute
+	// more times than this
+	MaxIncarnations int
+	// BufferWrites keeps the writes of an incarnation private until it completes successfully. Otherwise they are
+	// visible as estimates as soon as they are made, so other transactions that read them wait for this one rather
+	// than going on to read an older value.
+	BufferWrites bool
+	// ErrorPolicy decides whether a failing task fails the block or is recorded as the result of its transaction
+	ErrorPolicy ErrorPolicy
+	// ReuseMVHashMap keeps the MVHashMap of a block to Reset and use for the next, rather than allocating a new one
+	// every time. Blocks executed at the same time by the Executor each still get their own.
+	ReuseMVHashMap bool
+
+	// Logger receives diagnostics, by default nothing is logged - see NewTextLogger
+	Logger Logger
+	Hooks  ExecHooks
+	// Tracer, if set, records the timeline of executions and validations
+	Tracer *Tracer
+}
+
+type Executor struct {
+	opts ExecOptions
+	// makeDispatcher replaces the worker goroutines of ExecuteParallel, for example to control the interleaving of
+	// tasks in tests
+	makeDispatcher func(numTasks int) dispatcher
+
+	mu sync.Mutex
+	// mvh is the map kept for the next block if ReuseMVHashMap is set
+	mvh *MVHashMap
+}
+
+func NewExecutor(opts ExecOptions) *Executor {
+	if opts.Workers <= 0 {
+		opts.Workers = defaultNumWorkers
+	}
+	if opts.ValidationBatch <= 0 {
+		opts.ValidationBatch = defaultValidationBatch
+	}
+	if opts.Logger == nil {
+		opts.Logger = nopLogger{}
+	}
+	return &Executor{opts: opts}
+}
+
+func (e *Executor) Options() ExecOptions {
+	return e.opts
+}
+
+func (e *Executor) startDispatcher(numTasks int) dispatcher {
+	if e.makeDispatcher != nil {
+		return e.makeDispatcher(numTasks)
+	}
+	return startWorkerDispatcher(e.opts.Workers, numTasks, e.opts.Logger)
+}
+
+func (e *Executor) acquireMVHashMap() *MVHashMap {
+	if e.opts.ReuseMVHashMap {
+		e.mu.Lock()
+		mvh := e.mvh
+		e.mvh = nil
+		e.mu.Unlock()
+		if mvh != nil {
+			return mvh
+		}
+	}
+	return MakeMVHashMap()
+}
+
+// releaseMVHashMap: keeps the map for the next block, unless workers were abandoned in a task since they may still be
+//  using it
+func (e *Executor) releaseMVHashMap(mvh *MVHashMap, abandoned bool) {
+	if !e.opts.ReuseMVHashMap || abandoned {
+		return
+	}
+	mvh.Reset()
+	e.mu.Lock()
+	e.mvh = mvh
+	e.mu.Unlock()
+}
+
+// exceedsMaxIncarnations: incarnations are numbered from zero so this is the case once MaxIncarnations is reached
+func (e *Executor) exceedsMaxIncarnations(ver Version) bool {
+	return e.opts.MaxIncarnations > 0 && ver.Incarnation >= e.opts.MaxIncarnations
+}
+
+func (e *Executor) onExecute(res ExecResult) {
+	if e.opts.Tracer != nil {
+		e.opts.Tracer.addExecution(res)
+	}
+	if e.opts.Hooks.OnExecute == nil {
+		return
+	}
+	ev := ExecEvent{Version: res.ver, DepIdx: res.depIdx}
+	if res.err == errExecAbort {
+		ev.Aborted, ev.DepPath = true, res.depPath
+	} else {
+		ev.Err = res.err
+	}
+	e.opts.Hooks.OnExecute(ev)
+}
+
+func (e *Executor) onValidate(worker int, ver Version, valid bool, invalidPath []byte, start time.Time, d time.Duration) {
+	if e.opts.Tracer != nil {
+		e.opts.Tracer.addValidation(worker, ver, valid, start, d)
+	}
+	if e.opts.Hooks.OnValidate != nil {
+		e.opts.Hooks.OnValidate(ValidateEvent{Version: ver, Valid: valid, Path: invalidPath})
+	}
+}
+
+func (e *Executor) onBlock(stats ExecStats, mvh *MVHashMap, err error) {
+	if e.opts.Hooks.OnBlock != nil {
+		e.opts.Hooks.OnBlock(BlockEvent{Stats: stats, NumKeys: mvh.NumKeys(), Err: err})
+	}
+}
@@ -0,0 +1,296 @@
+package block_stm
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExecutorDefaults(t *testing.T) {
+	opts := NewExecutor(ExecOptions{}).Options()
+	require.Equal(t, defaultNumWorkers, opts.Workers)
+	require.Equal(t, defaultValidationBatch, opts.ValidationBatch)
+	require.Equal(t, 0, opts.MaxIncarnations)
+	require.NotNil(t, opts.Logger)
+
+	opts = NewExecutor(ExecOptions{Workers: 64, ValidationBatch: 20}).Options()
+	require.Equal(t, 64, opts.Workers)
+	require.Equal(t, 20, opts.ValidationBatch)
+}
+
+func makeTestConflictTasks(numTasks int) (exec []ExecTask) {
+	for i := 0; i < numTasks; i++ {
+		exec = append(exec, testConflictExecTask{testExecTask{num: i, wait: time.Millisecond}})
+	}
+	return
+}
+
+func TestExecutorHooks(t *testing.T) {
+	var cntExec, cntAbort, cntValidate, cntInvalid int32
+	var blocks []BlockEvent
+	hooks := ExecHooks{
+		OnExecute: func(ev ExecEvent) {
+			atomic.AddInt32(&cntExec, 1)
+			if ev.Aborted {
+				atomic.AddInt32(&cntAbort, 1)
+			}
+		},
+		OnValidate: func(ev ValidateEvent) {
+			atomic.AddInt32(&cntValidate, 1)
+			if !ev.Valid {
+				atomic.AddInt32(&cntInvalid, 1)
+			}
+		},
+		OnBlock: func(ev BlockEvent) {
+			blocks = append(blocks, ev)
+		},
+	}
+
+	exec := makeTestConflictTasks(20)
+	var rw testBaseReadWrite
+
+	// a single worker executes everything in order so there are no conflicts
+	e := NewExecutor(ExecOptions{Workers: 1, Hooks: hooks})
+	for _, execute := range testExecutors(e) {
+		cntExec, cntAbort, cntValidate, cntInvalid = 0, 0, 0, 0
+		txIO, _, _, err := execute(exec, &rw)
+		require.NoError(t, err)
+		require.True(t, validateConflictTxOutput(txIO))
+		require.Equal(t, int32(20), cntExec)
+		require.Equal(t, int32(0), cntAbort)
+		require.Equal(t, int32(20), cntValidate)
+		require.Equal(t, int32(0), cntInvalid)
+	}
+
+	e = NewExecutor(ExecOptions{Hooks: hooks})
+	for _, execute := range testExecutors(e) {
+		cntExec, cntAbort, cntValidate, cntInvalid = 0, 0, 0, 0
+		txIO, _, _, err := execute(exec, &rw)
+		require.NoError(t, err)
+		require.True(t, validateConflictTxOutput(txIO))
+		require.Greater(t, cntExec, int32(20))
+		require.GreaterOrEqual(t, cntValidate-cntInvalid, int32(20), "every tx is eventually validated")
+	}
+
+	// one block event per execution, with the same stats as returned
+	blocks = nil
+	_, _, stats, err := e.ExecuteParallel(exec, &rw)
+	require.NoError(t, err)
+	require.Len(t, blocks, 1)
+	require.Equal(t, stats, blocks[0].Stats)
+	require.Greater(t, blocks[0].NumKeys, 0)
+	require.NoError(t, blocks[0].Err)
+
+	e = NewExecutor(ExecOptions{MaxIncarnations: 1, Hooks: hooks})
+	_, _, _, err = e.ExecuteScheduled(makeTestConflictTasks(50), &rw)
+	require.ErrorIs(t, err, ErrMaxIncarnations)
+	require.Len(t, blocks, 2)
+	require.Equal(t, err, blocks[1].Err)
+}
+
+func TestExecutorMaxIncarnations(t *testing.T) {
+	exec := makeTestConflictTasks(50)
+	var rw testBaseReadWrite
+
+	e := NewExecutor(ExecOptions{MaxIncarnations: 1})
+	for _, execute := range testExecutors(e) {
+		_, _, _, err := execute(exec, &rw)
+		require.ErrorIs(t, err, ErrMaxIncarnations)
+	}
+
+	e = NewExecutor(ExecOptions{MaxIncarnations: 1, Workers: 1})
+	_, _, _, err := e.ExecuteParallel(exec, &rw)
+	require.NoError(t, err, "no conflicts with a single worker")
+}
+
+var errTestRevert = errors.New("reverted")
+
+type testRevertExecTask struct {
+	testExecTask
+}
+
+// increments the counter like testConflictExecTask but every 5th tx then fails, so its partial write must be discarded
+func (t testRevertExecTask) Execute(rw BaseReadWrite) error {
+	v, err := rw.Read([]byte("test-key-0"))
+	if err != nil {
+		return err
+	}
+	var cnt uint32
+	if v != nil {
+		cnt = binary.BigEndian.Uint32(v)
+	}
+	time.Sleep(t.wait)
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], cnt+1)
+	if err = rw.Write([]byte("test-key-0"), b[:]); err != nil {
+		return err
+	}
+	if t.num%5 == 0 {
+		return errTestRevert
+	}
+	return nil
+}
+
+func TestExecutorErrorPolicy(t *testing.T) {
+	var exec []ExecTask
+	for i := 0; i < 50; i++ {
+		exec = append(exec, testRevertExecTask{testExecTask{num: i, wait: time.Millisecond}})
+	}
+	var rw testBaseReadWrite
+
+	e := NewExecutor(ExecOptions{})
+	for _, execute := range testExecutors(e) {
+		_, _, _, err := execute(exec, &rw)
+		require.ErrorIs(t, err, errTestRevert)
+		var txErr *TxnError
+		require.True(t, errors.As(err, &txErr))
+		require.Equal(t, 0, txErr.Version.TxnIndex)
+	}
+
+	for _, bufferWrites := range []bool{false, true} {
+		e = NewExecutor(ExecOptions{ErrorPolicy: ErrorPolicyRecordFailure, BufferWrites: bufferWrites})
+		for _, execute := range testExecutors(e) {
+			txIO, diff, _, err := execute(exec, &rw)
+			require.NoError(t, err)
+			for i := range exec {
+				if i%5 == 0 {
+					require.ErrorIs(t, txIO.Err(i), errTestRevert)
+					require.Empty(t, txIO.writeSet(i))
+					require.NotEmpty(t, txIO.readSet(i))
+				} else {
+					require.NoError(t, txIO.Err(i))
+				}
+			}
+			require.Len(t, diff, 1)
+			require.Equal(t, uint32(40), binary.BigEndian.Uint32(diff[0].Val))
+		}
+	}
+}
+
+type testSpeculativeExecTask struct {
+	testExecTask
+}
+
+// the first tx writes the key after a delay and the rest fail if they don't read its value, which only happens
+//  speculatively
+func (t testSpeculativeExecTask) Execute(rw BaseReadWrite) error {
+	if t.num == 0 {
+		time.Sleep(t.wait)
+		return rw.Write([]byte("test-key-0"), []byte{1})
+	}
+	v, err := rw.Read([]byte("test-key-0"))
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(v, []byte{1}) {
+		return errTestRevert
+	}
+	return nil
+}
+
+func TestExecutorErrorPolicySpeculativeFailure(t *testing.T) {
+	var exec []ExecTask
+	for i := 0; i < 10; i++ {
+		exec = append(exec, testSpeculativeExecTask{testExecTask{num: i, wait: 10 * time.Millisecond}})
+	}
+	var rw testBaseReadWrite
+
+	for _, bufferWrites := range []bool{false, true} {
+		e := NewExecutor(ExecOptions{BufferWrites: bufferWrites})
+		for _, execute := range testExecutors(e) {
+			txIO, diff, _, err := execute(exec, &rw)
+			require.NoError(t, err)
+			for i := range exec {
+				require.NoError(t, txIO.Err(i))
+			}
+			require.Len(t, diff, 1)
+		}
+	}
+}
+
+type testPanicExecTask struct {
+	testExecTask
+}
+
+func (t testPanicExecTask) Execute(rw BaseReadWrite) error {
+	if _, err := rw.Read([]byte("test-key-0")); err != nil {
+		return err
+	}
+	panic("bad contract")
+}
+
+func TestExecutorRecoverPanic(t *testing.T) {
+	exec := makeTestConflictTasks(20)
+	exec[7] = testPanicExecTask{testExecTask{num: 7}}
+	var rw testBaseReadWrite
+
+	e := NewExecutor(ExecOptions{})
+	for _, execute := range testExecutors(e) {
+		_, _, _, err := execute(exec, &rw)
+		var panicErr *PanicError
+		require.True(t, errors.As(err, &panicErr))
+		require.Equal(t, 7, panicErr.Version.TxnIndex)
+		require.Equal(t, "bad contract", panicErr.Value)
+		require.Contains(t, string(panicErr.Stack), "testPanicExecTask")
+	}
+
+	e = NewExecutor(ExecOptions{ErrorPolicy: ErrorPolicyRecordFailure})
+	for _, execute := range testExecutors(e) {
+		txIO, diff, _, err := execute(exec, &rw)
+		require.NoError(t, err)
+		var panicErr *PanicError
+		require.True(t, errors.As(txIO.Err(7), &panicErr))
+		require.Equal(t, uint32(19), binary.BigEndian.Uint32(diff[0].Val))
+	}
+}
+
+func TestExecutorReuseMVHashMap(t *testing.T) {
+	var rw testBaseReadWrite
+	e := NewExecutor(ExecOptions{ReuseMVHashMap: true, ErrorPolicy: ErrorPolicyRecordFailure})
+	fresh := NewExecutor(ExecOptions{ErrorPolicy: ErrorPolicyRecordFailure})
+
+	var mvh *MVHashMap
+	for seed := int64(0); seed < 10; seed++ {
+		exec := makeTestPrograms(seed, 50, 4, 8)
+		_, expected, _, err := fresh.ExecuteParallel(exec, &rw)
+		require.NoError(t, err)
+		for _, execute := range testExecutors(e) {
+			_, diff, _, err := execute(exec, &rw)
+			require.NoError(t, err)
+			require.Empty(t, Compare(expected, diff), "seed %v", seed)
+
+			// the same map every time, empty in between blocks
+			require.NotNil(t, e.mvh)
+			if mvh != nil {
+				require.Same(t, mvh, e.mvh)
+			}
+			mvh = e.mvh
+			require.Equal(t, 0, mvh.NumKeys())
+		}
+	}
+
+	// abandoned workers may still be using the map of a cancelled block so it isn't kept
+	release := make(chan struct{})
+	defer close(release)
+	exec := makeTestConflictTasks(10)
+	exec[5] = testBlockingExecTask{exec[5], release}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, _, _, err := e.ExecuteParallelContext(ctx, exec, &rw)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Nil(t, e.mvh)
+
+	// nor is it if the block had already failed before the workers were abandoned
+	e = NewExecutor(ExecOptions{ReuseMVHashMap: true, MaxIncarnations: 1, Workers: 4})
+	exec = append([]ExecTask{testBlockingExecTask{testIndependentExecTask{}, release}}, makeTestConflictTasks(50)...)
+	ctx, cancel = context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	_, _, _, err = e.ExecuteScheduledContext(ctx, exec, &rw)
+	require.ErrorIs(t, err, ErrMaxIncarnations)
+	require.Nil(t, e.mvh)
+}
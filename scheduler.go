@@ -1,29 +1,176 @@
 package block_stm
 
-import "sync/atomic"
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
 
+// SchedulerTask is one of SchedulerTaskExecution, SchedulerTaskValidation or SchedulerTaskDone
 type SchedulerTask interface{}
 
+type SchedulerTaskExecution struct {
+	Version
+}
+
+type SchedulerTaskValidation struct {
+	Version
+}
+
 type SchedulerTaskDone struct{}
 
+const (
+	statusReadyToExecute = 0
+	statusExecuting      = 1
+	statusExecuted       = 2
+	statusAborting       = 3
+)
+
+type txnStatus struct {
+	mu          sync.Mutex
+	incarnation int
+	status      int
+}
+
+// txnDependency: transactions that are parked until this one finishes its next execution
+type txnDependency struct {
+	mu   sync.Mutex
+	txns []int
+}
+
+// Scheduler is the collaborative scheduler from the Block-STM paper. Worker threads call NextTask to pull execution
+// and validation tasks and report back with FinishExecution, TryValidationAbort and FinishValidation. All state is
+// either atomic or protected by per-transaction locks so there is no central coordinator.
 type Scheduler struct {
-	executionIdx  uint32
-	validationIdx uint32
-	decreaseCnt   uint32
+	executionIdx   uint32
+	validationIdx  uint32
+	decreaseCnt    uint32
+	numActiveTasks int32
+	doneMarker     uint32
+
+	numTxns       uint32
+	txnStatus     []txnStatus
+	txnDependency []txnDependency
 }
 
-func MakeScheduler() *Scheduler {
+func MakeScheduler(numTxns int) *Scheduler {
 	return &Scheduler{
 		executionIdx:  0,
 		validationIdx: 0,
 		decreaseCnt:   0,
+		numTxns:       uint32(numTxns),
+		txnStatus:     make([]txnStatus, numTxns),
+		txnDependency: make([]txnDependency, numTxns),
 	}
 }
 
 func (s *Scheduler) Done() bool {
-	return false // TODO
+	return atomic.LoadUint32(&s.doneMarker) == 1
+}
+
+// halt: stops all workers at their next call to NextTask, for example when execution fails
+func (s *Scheduler) halt() {
+	atomic.StoreUint32(&s.doneMarker, 1)
+}
+
+// countExecuted: number of transactions whose latest incarnation has finished executing
+func (s *Scheduler) countExecuted() (cnt int) {
+	for i := range s.txnStatus {
+		ts := &s.txnStatus[i]
+		ts.mu.Lock()
+		if ts.status == statusExecuted {
+			cnt++
+		}
+		ts.mu.Unlock()
+	}
+	return
+}
+
+// decreaseExecutionIdx: execution index is only ever moved down to a lower target
+func (s *Scheduler) decreaseExecutionIdx(target int) {
+	for {
+		cur := atomic.LoadUint32(&s.executionIdx)
+		if uint32(target) >= cur || atomic.CompareAndSwapUint32(&s.executionIdx, cur, uint32(target)) {
+			break
+		}
+	}
+	atomic.AddUint32(&s.decreaseCnt, 1)
+}
+
+func (s *Scheduler) decreaseValidationIdx(target int) {
+	for {
+		cur := atomic.LoadUint32(&s.validationIdx)
+		if uint32(target) >= cur || atomic.CompareAndSwapUint32(&s.validationIdx, cur, uint32(target)) {
+			break
+		}
+	}
+	atomic.AddUint32(&s.decreaseCnt, 1)
 }
 
+// checkDone: the decrease count is read first so that a concurrent decrease of either index between the checks below
+//  is detected and we don't falsely declare completion
+func (s *Scheduler) checkDone() {
+	observedCnt := atomic.LoadUint32(&s.decreaseCnt)
+	execIdx, valIdx := atomic.LoadUint32(&s.executionIdx), atomic.LoadUint32(&s.validationIdx)
+	minIdx := execIdx
+	if valIdx < minIdx {
+		minIdx = valIdx
+	}
+	if minIdx >= s.numTxns && atomic.LoadInt32(&s.numActiveTasks) == 0 && observedCnt == atomic.LoadUint32(&s.decreaseCnt) {
+		atomic.StoreUint32(&s.doneMarker, 1)
+	}
+}
+
+// tryIncarnate: expects the caller to have already incremented numActiveTasks, which is released if the transaction is
+//  not ready to execute
+func (s *Scheduler) tryIncarnate(txIdx int) (ver Version, ok bool) {
+	if txIdx < int(s.numTxns) {
+		ts := &s.txnStatus[txIdx]
+		ts.mu.Lock()
+		if ts.status == statusReadyToExecute {
+			ts.status = statusExecuting
+			ver, ok = Version{TxnIndex: txIdx, Incarnation: ts.incarnation}, true
+		}
+		ts.mu.Unlock()
+		if ok {
+			return
+		}
+	}
+	atomic.AddInt32(&s.numActiveTasks, -1)
+	return
+}
+
+func (s *Scheduler) nextVersionToExecute() (Version, bool) {
+	if atomic.LoadUint32(&s.executionIdx) >= s.numTxns {
+		s.checkDone()
+		return Version{}, false
+	}
+	atomic.AddInt32(&s.numActiveTasks, 1)
+	idxToExecute := atomic.AddUint32(&s.executionIdx, 1) - 1
+	return s.tryIncarnate(int(idxToExecute))
+}
+
+func (s *Scheduler) nextVersionToValidate() (Version, bool) {
+	if atomic.LoadUint32(&s.validationIdx) >= s.numTxns {
+		s.checkDone()
+		return Version{}, false
+	}
+	atomic.AddInt32(&s.numActiveTasks, 1)
+	idxToValidate := atomic.AddUint32(&s.validationIdx, 1) - 1
+	if idxToValidate < s.numTxns {
+		ts := &s.txnStatus[idxToValidate]
+		ts.mu.Lock()
+		inc, status := ts.incarnation, ts.status
+		ts.mu.Unlock()
+		if status == statusExecuted {
+			return Version{TxnIndex: int(idxToValidate), Incarnation: inc}, true
+		}
+	}
+	atomic.AddInt32(&s.numActiveTasks, -1)
+	return Version{}, false
+}
+
+// NextTask: blocks until there is either an execution or validation task for the caller, or all work is complete
 func (s *Scheduler) NextTask() SchedulerTask {
 	for {
 		if s.Done() {
@@ -31,11 +178,131 @@ func (s *Scheduler) NextTask() SchedulerTask {
 		}
 
 		if atomic.LoadUint32(&s.validationIdx) < atomic.LoadUint32(&s.executionIdx) {
+			if ver, ok := s.nextVersionToValidate(); ok {
+				return SchedulerTaskValidation{ver}
+			}
+		} else {
+			if ver, ok := s.nextVersionToExecute(); ok {
+				return SchedulerTaskExecution{ver}
+			}
+		}
+		runtime.Gosched()
+	}
+}
+
+// setReadyStatus: moves an aborting transaction to ready with its next incarnation
+func (s *Scheduler) setReadyStatus(txIdx int) {
+	ts := &s.txnStatus[txIdx]
+	ts.mu.Lock()
+	ts.incarnation++
+	ts.status = statusReadyToExecute
+	ts.mu.Unlock()
+}
+
+// reincarnate: an incarnation that aborted with a read dependency that has already been resolved is retried straight
+//  away with the next incarnation since its writes are already visible under the current one
+func (s *Scheduler) reincarnate(txIdx int) Version {
+	ts := &s.txnStatus[txIdx]
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.incarnation++
+	return Version{TxnIndex: txIdx, Incarnation: ts.incarnation}
+}
+
+// AddDependency: parks txIdx, which aborted on reading an estimate written by blockingTxIdx, until the blocking
+//  transaction finishes its next execution. Returns false if the blocking transaction has already executed in the
+//  meantime, in which case the caller should execute again straight away.
+func (s *Scheduler) AddDependency(txIdx, blockingTxIdx int) bool {
+	dep := &s.txnDependency[blockingTxIdx]
+	dep.mu.Lock()
+	defer dep.mu.Unlock()
 
+	bs := &s.txnStatus[blockingTxIdx]
+	bs.mu.Lock()
+	executed := bs.status == statusExecuted
+	bs.mu.Unlock()
+	if executed {
+		return false
+	}
+
+	ts := &s.txnStatus[txIdx]
+	ts.mu.Lock()
+	ts.status = statusAborting
+	ts.mu.Unlock()
+
+	dep.txns = append(dep.txns, txIdx)
+	atomic.AddInt32(&s.numActiveTasks, -1)
+	return true
+}
+
+// resumeDependencies: parked transactions become ready with their next incarnation and execution is moved back so
+//  they are picked up again
+func (s *Scheduler) resumeDependencies(txns []int) {
+	if len(txns) == 0 {
+		return
+	}
+	minIdx := txns[0]
+	for _, tx := range txns {
+		s.setReadyStatus(tx)
+		if tx < minIdx {
+			minIdx = tx
+		}
+	}
+	s.decreaseExecutionIdx(minIdx)
+}
+
+// FinishExecution: records that the incarnation completed and, unless validation already has to be redone from this
+//  transaction because it wrote to a new location, hands the validation of it directly back to the caller
+func (s *Scheduler) FinishExecution(ver Version, wroteNewPath bool) SchedulerTask {
+	ts := &s.txnStatus[ver.TxnIndex]
+	ts.mu.Lock()
+	ts.status = statusExecuted
+	ts.mu.Unlock()
+
+	dep := &s.txnDependency[ver.TxnIndex]
+	dep.mu.Lock()
+	resume := dep.txns
+	dep.txns = nil
+	dep.mu.Unlock()
+	s.resumeDependencies(resume)
+
+	if atomic.LoadUint32(&s.validationIdx) > uint32(ver.TxnIndex) {
+		if wroteNewPath {
+			s.decreaseValidationIdx(ver.TxnIndex)
+		} else {
+			return SchedulerTaskValidation{ver}
 		}
 	}
+	atomic.AddInt32(&s.numActiveTasks, -1)
+	return nil
 }
 
-func (s *Scheduler) tryValidateNextVersion() {
+// TryValidationAbort: only one validator can abort a given incarnation
+func (s *Scheduler) TryValidationAbort(ver Version) bool {
+	ts := &s.txnStatus[ver.TxnIndex]
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.status == statusExecuted && ts.incarnation == ver.Incarnation {
+		ts.status = statusAborting
+		return true
+	}
+	return false
+}
 
+// FinishValidation: if the validation aborted the transaction then all higher transactions have to be validated again
+//  and the next incarnation is handed directly back to the caller if possible
+func (s *Scheduler) FinishValidation(txIdx int, aborted bool) SchedulerTask {
+	if aborted {
+		s.setReadyStatus(txIdx)
+		s.decreaseValidationIdx(txIdx + 1)
+		if atomic.LoadUint32(&s.executionIdx) > uint32(txIdx) {
+			if ver, ok := s.tryIncarnate(txIdx); ok {
+				return SchedulerTaskExecution{ver}
+			}
+			// tryIncarnate has already released the active task
+			return nil
+		}
+	}
+	atomic.AddInt32(&s.numActiveTasks, -1)
+	return nil
 }
@@ -0,0 +1,139 @@
+package block_stm
+
+import (
+	"github.com/stretchr/testify/require"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSchedulerBasics(t *testing.T) {
+
+	s := MakeScheduler(2)
+
+	require.Equal(t, SchedulerTaskExecution{Version{0, 0}}, s.NextTask())
+	require.Equal(t, SchedulerTaskExecution{Version{1, 0}}, s.NextTask())
+
+	// validation idx has already moved past tx0 so its validation is handed straight back ...
+	require.Equal(t, SchedulerTaskValidation{Version{0, 0}}, s.FinishExecution(Version{0, 0}, false))
+	require.Nil(t, s.FinishValidation(0, false))
+
+	require.False(t, s.Done())
+
+	// ... but not tx1 so that validation is picked up via NextTask
+	require.Nil(t, s.FinishExecution(Version{1, 0}, false))
+	require.Equal(t, SchedulerTaskValidation{Version{1, 0}}, s.NextTask())
+
+	// only one validator can abort a given incarnation
+	require.True(t, s.TryValidationAbort(Version{1, 0}))
+	require.False(t, s.TryValidationAbort(Version{1, 0}))
+
+	require.Equal(t, SchedulerTaskExecution{Version{1, 1}}, s.FinishValidation(1, true))
+
+	// validation idx is now past tx1 so validation of the new incarnation is handed straight back
+	require.Equal(t, SchedulerTaskValidation{Version{1, 1}}, s.FinishExecution(Version{1, 1}, false))
+	require.False(t, s.TryValidationAbort(Version{1, 0}), "stale incarnation can't be aborted")
+	require.Nil(t, s.FinishValidation(1, false))
+
+	require.Equal(t, SchedulerTaskDone{}, s.NextTask())
+	require.True(t, s.Done())
+}
+
+func TestSchedulerWroteNewPath(t *testing.T) {
+
+	s := MakeScheduler(3)
+	for i := 0; i < 3; i++ {
+		require.Equal(t, SchedulerTaskExecution{Version{i, 0}}, s.NextTask())
+	}
+
+	require.Equal(t, SchedulerTaskValidation{Version{0, 0}}, s.FinishExecution(Version{0, 0}, false))
+	require.Nil(t, s.FinishValidation(0, false))
+	require.Nil(t, s.FinishExecution(Version{2, 0}, false))
+
+	// tx1 fails validation ...
+	require.Equal(t, SchedulerTaskValidation{Version{1, 0}}, s.FinishExecution(Version{1, 0}, false))
+	require.True(t, s.TryValidationAbort(Version{1, 0}))
+	require.Equal(t, SchedulerTaskExecution{Version{1, 1}}, s.FinishValidation(1, true))
+
+	// ... while tx2 is validated against the aborted incarnation
+	require.Equal(t, SchedulerTaskValidation{Version{2, 0}}, s.NextTask())
+	require.Nil(t, s.FinishValidation(2, false))
+	require.Equal(t, uint32(3), atomic.LoadUint32(&s.validationIdx))
+
+	// the new incarnation of tx1 writes a new path so validation has to restart from tx1
+	require.Nil(t, s.FinishExecution(Version{1, 1}, true))
+	require.Equal(t, uint32(1), atomic.LoadUint32(&s.validationIdx))
+
+	require.Equal(t, SchedulerTaskValidation{Version{1, 1}}, s.NextTask())
+	require.Nil(t, s.FinishValidation(1, false))
+	require.Equal(t, SchedulerTaskValidation{Version{2, 0}}, s.NextTask())
+	require.Nil(t, s.FinishValidation(2, false))
+
+	require.Equal(t, SchedulerTaskDone{}, s.NextTask())
+}
+
+func TestSchedulerConcurrent(t *testing.T) {
+
+	const numTxns = 1000
+	s := MakeScheduler(numTxns)
+
+	var execCnt [numTxns]int32
+	var wg sync.WaitGroup
+	for i := 0; i < defaultNumWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var task SchedulerTask
+			for {
+				if task == nil {
+					task = s.NextTask()
+				}
+				switch tt := task.(type) {
+				case SchedulerTaskExecution:
+					atomic.AddInt32(&execCnt[tt.TxnIndex], 1)
+					task = s.FinishExecution(tt.Version, false)
+				case SchedulerTaskValidation:
+					// fail the first validation of every 10th tx
+					aborted := tt.TxnIndex%10 == 0 && tt.Incarnation == 0 && s.TryValidationAbort(tt.Version)
+					task = s.FinishValidation(tt.TxnIndex, aborted)
+				case SchedulerTaskDone:
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for i := 0; i < numTxns; i++ {
+		if i%10 == 0 {
+			require.Equal(t, int32(2), execCnt[i])
+		} else {
+			require.Equal(t, int32(1), execCnt[i])
+		}
+	}
+}
+
+func TestSchedulerDependency(t *testing.T) {
+
+	s := MakeScheduler(2)
+	require.Equal(t, SchedulerTaskExecution{Version{0, 0}}, s.NextTask())
+	require.Equal(t, SchedulerTaskExecution{Version{1, 0}}, s.NextTask())
+
+	// tx1 reads an estimate of tx0 and is parked until tx0 executes
+	require.True(t, s.AddDependency(1, 0))
+	require.Equal(t, uint32(2), atomic.LoadUint32(&s.executionIdx))
+
+	require.Equal(t, SchedulerTaskValidation{Version{0, 0}}, s.FinishExecution(Version{0, 0}, false))
+	require.Equal(t, uint32(1), atomic.LoadUint32(&s.executionIdx), "tx1 is resumed")
+	require.Nil(t, s.FinishValidation(0, false))
+
+	require.Equal(t, SchedulerTaskExecution{Version{1, 1}}, s.NextTask())
+
+	// tx0 has executed so there is nothing to wait for
+	require.False(t, s.AddDependency(1, 0))
+
+	require.Nil(t, s.FinishExecution(Version{1, 1}, false))
+	require.Equal(t, SchedulerTaskValidation{Version{1, 1}}, s.NextTask())
+	require.Nil(t, s.FinishValidation(1, false))
+	require.Equal(t, SchedulerTaskDone{}, s.NextTask())
+}
@@ -0,0 +1,142 @@
+package block_stm
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// ExecuteSerial: reference executor that runs tasks one after another in block order against a plain overlay of
+//  storage. It returns the same shape of results as ExecuteParallel, with every transaction at incarnation 0, so the
+//  two can be compared.
+func ExecuteSerial(tasks []ExecTask, rw BaseReadWrite) (lastTxIO *TxnInputOutput, diff StateDiff, stats ExecStats, err error) {
+	return NewExecutor(ExecOptions{}).ExecuteSerial(tasks, rw)
+}
+
+// ExecuteSerial: only the error policy of the executor options applies
+func (e *Executor) ExecuteSerial(tasks []ExecTask, rw BaseReadWrite) (lastTxIO *TxnInputOutput, diff StateDiff, stats ExecStats, err error) {
+
+	start := time.Now()
+	stats.NumTxns, stats.Workers = len(tasks), 1
+	defer func() {
+		// every transaction is executed exactly once
+		stats.finish(start, stats.ExecTime, func(int) int { return 0 })
+	}()
+
+	lastTxIO = MakeTxnInputOutput(len(tasks))
+	overlay := make(map[string]WriteDescriptor)
+
+	for txIdx, task := range tasks {
+		sv := serialView{ver: Version{TxnIndex: txIdx}, rw: rw, overlay: overlay,
+			readMap: make(map[string]ReadDescriptor), writeMap: make(map[string]WriteDescriptor)}
+		execStart := time.Now()
+		txErr := executeTask(task, &sv, sv.ver)
+		stats.ExecTime += time.Since(execStart)
+		stats.Executions++
+		if txErr != nil {
+			stats.Failures++
+			if e.opts.ErrorPolicy == ErrorPolicyFailBlock {
+				return nil, nil, stats, &TxnError{Version: sv.ver, Err: txErr}
+			}
+		} else {
+			stats.Successes++
+		}
+
+		var txIn TxnInput
+		for _, v := range sv.readMap {
+			txIn = append(txIn, v)
+		}
+		var txOut TxnOutput
+		// the writes of a failed transaction are discarded
+		if txErr == nil {
+			for k, v := range sv.writeMap {
+				txOut = append(txOut, v)
+				overlay[k] = v
+			}
+		}
+		lastTxIO.recordRead(txIdx, txIn)
+		lastTxIO.recordWrite(txIdx, txOut)
+		lastTxIO.recordErr(txIdx, txErr)
+	}
+
+	for _, v := range overlay {
+		diff = append(diff, v)
+	}
+	sort.Slice(diff, func(i, j int) bool {
+		return bytes.Compare(diff[i].Path, diff[j].Path) < 0
+	})
+	return
+}
+
+// serialView: what a single transaction sees during serial execution - its own writes, then the writes of all lower
+//  transactions, then storage
+type serialView struct {
+	ver     Version
+	rw      BaseReadWrite
+	overlay map[string]WriteDescriptor
+
+	readMap  map[string]ReadDescriptor
+	writeMap map[string]WriteDescriptor
+}
+
+func (sv *serialView) Read(k []byte) (v []byte, err error) {
+	if wd, ok := sv.writeMap[string(k)]; ok {
+		return wd.Val, nil
+	}
+	rd := ReadDescriptor{Path: k}
+	if wd, ok := sv.overlay[string(k)]; ok {
+		v = wd.Val
+		rd.Kind = ReadKindMap
+		rd.V = wd.V
+	} else {
+		v, err = sv.rw.Read(k)
+		rd.Kind = ReadKindStorage
+		rd.V = Version{TxnIndex: -1, Incarnation: -1}
+	}
+	if _, ok := sv.readMap[string(k)]; !ok {
+		sv.readMap[string(k)] = rd
+	}
+	return
+}
+
+func (sv *serialView) Write(k, v []byte) error {
+	sv.writeMap[string(k)] = WriteDescriptor{Path: k, V: sv.ver, Val: v}
+	return nil
+}
+
+// Mismatch: a path whose final value differs between two state diffs. A nil value means the diff has no write to the
+//  path.
+type Mismatch struct {
+	Path     []byte
+	Expected []byte
+	Actual   []byte
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("%x: expected %x, actual %x", m.Path, m.Expected, m.Actual)
+}
+
+// Compare: reports every path whose final value in actual differs from expected, ordered by path. Only values are
+//  compared since the incarnations that wrote them will generally differ, for example between ExecuteSerial and
+//  ExecuteParallel.
+func Compare(expected, actual StateDiff) (mismatches []Mismatch) {
+	actualVals := make(map[string][]byte, len(actual))
+	for _, v := range actual {
+		actualVals[string(v.Path)] = v.Val
+	}
+	for _, v := range expected {
+		actualVal, ok := actualVals[string(v.Path)]
+		if !ok || !bytes.Equal(v.Val, actualVal) {
+			mismatches = append(mismatches, Mismatch{Path: v.Path, Expected: v.Val, Actual: actualVal})
+		}
+		delete(actualVals, string(v.Path))
+	}
+	for k, v := range actualVals {
+		mismatches = append(mismatches, Mismatch{Path: []byte(k), Actual: v})
+	}
+	sort.Slice(mismatches, func(i, j int) bool {
+		return bytes.Compare(mismatches[i].Path, mismatches[j].Path) < 0
+	})
+	return
+}
@@ -0,0 +1,73 @@
+package block_stm
+
+import (
+	"encoding/binary"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestExecuteSerial(t *testing.T) {
+	exec := makeTestConflictTasks(20)
+	var rw testBaseReadWrite
+
+	txIO, diff, _, err := ExecuteSerial(exec, &rw)
+	require.NoError(t, err)
+	require.True(t, validateConflictTxOutput(txIO))
+	require.Len(t, diff, 1)
+	require.Equal(t, Version{19, 0}, diff[0].V)
+
+	// every tx after the first reads the write of the one before
+	require.Equal(t, ReadKindStorage, txIO.readSet(0)[0].Kind)
+	for i := 1; i < len(exec); i++ {
+		require.Equal(t, ReadDescriptor{Path: []byte("test-key-0"), Kind: ReadKindMap, V: Version{i - 1, 0}}, txIO.readSet(i)[0])
+	}
+
+	_, parallelDiff, _, err := ExecuteParallel(exec, &rw)
+	require.NoError(t, err)
+	require.Empty(t, Compare(diff, parallelDiff))
+}
+
+func TestExecuteSerialErrorPolicy(t *testing.T) {
+	var exec []ExecTask
+	for i := 0; i < 20; i++ {
+		exec = append(exec, testRevertExecTask{testExecTask{num: i}})
+	}
+	var rw testBaseReadWrite
+
+	_, _, _, err := ExecuteSerial(exec, &rw)
+	require.ErrorIs(t, err, errTestRevert)
+	var txErr *TxnError
+	require.ErrorAs(t, err, &txErr)
+	require.Equal(t, Version{0, 0}, txErr.Version)
+
+	e := NewExecutor(ExecOptions{ErrorPolicy: ErrorPolicyRecordFailure})
+	txIO, diff, _, err := e.ExecuteSerial(exec, &rw)
+	require.NoError(t, err)
+	require.ErrorIs(t, txIO.Err(5), errTestRevert)
+	require.Empty(t, txIO.writeSet(5))
+	require.Equal(t, uint32(16), binary.BigEndian.Uint32(diff[0].Val))
+
+	_, parallelDiff, _, err := e.ExecuteParallel(exec, &rw)
+	require.NoError(t, err)
+	require.Empty(t, Compare(diff, parallelDiff))
+}
+
+func TestCompare(t *testing.T) {
+	expected := StateDiff{
+		{Path: []byte("a"), Val: []byte{1}},
+		{Path: []byte("b"), Val: []byte{2}},
+		{Path: []byte("c"), Val: []byte{3}},
+	}
+	require.Empty(t, Compare(expected, expected))
+
+	actual := StateDiff{
+		{Path: []byte("a"), V: Version{3, 2}, Val: []byte{1}},
+		{Path: []byte("c"), Val: []byte{4}},
+		{Path: []byte("d"), Val: []byte{5}},
+	}
+	require.Equal(t, []Mismatch{
+		{Path: []byte("b"), Expected: []byte{2}},
+		{Path: []byte("c"), Expected: []byte{3}, Actual: []byte{4}},
+		{Path: []byte("d"), Actual: []byte{5}},
+	}, Compare(expected, actual))
+}
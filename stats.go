@@ -0,0 +1,79 @@
+package block_stm
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// ExecStats: diagnostics of the execution of a block, for example to decide whether parallel execution is paying off
+type ExecStats struct {
+	NumTxns int
+	Workers int
+
+	Executions         int64
+	Successes          int64
+	Failures           int64
+	Aborts             int64
+	Validations        int64
+	ValidationFailures int64
+
+	// Incarnations is the number of incarnations of each transaction, 1 if it never had to be executed again
+	Incarnations []int
+
+	WallTime time.Duration
+	// ExecTime and ValidationTime are summed over all executions and validations, so can exceed WallTime
+	ExecTime       time.Duration
+	ValidationTime time.Duration
+	// Utilization is the fraction of the available worker time spent executing tasks, or validating them if that is
+	// done by the workers too
+	Utilization float64
+
+	// conflicts attributes aborts and validation failures to paths, see BuildConflictReport
+	conflicts *conflictCounts
+}
+
+func (s ExecStats) String() string {
+	return fmt.Sprintf("%v execs: %v success, %v failed, %v aborts; %v validations: %v failures; wall %v, exec %v, validation %v, utilization %.2f",
+		s.Executions, s.Successes, s.Failures, s.Aborts, s.Validations, s.ValidationFailures,
+		s.WallTime, s.ExecTime, s.ValidationTime, s.Utilization)
+}
+
+func (s ExecStats) fields() []Field {
+	return []Field{F("execs", s.Executions), F("success", s.Successes), F("failed", s.Failures), F("aborts", s.Aborts),
+		F("validations", s.Validations), F("validationFailures", s.ValidationFailures), F("wallTime", s.WallTime),
+		F("execTime", s.ExecTime), F("validationTime", s.ValidationTime), F("utilization", s.Utilization)}
+}
+
+// load: snapshot of counters that may still be updated concurrently
+func (s *ExecStats) load() ExecStats {
+	return ExecStats{
+		NumTxns:            s.NumTxns,
+		Workers:            s.Workers,
+		Executions:         atomic.LoadInt64(&s.Executions),
+		Successes:          atomic.LoadInt64(&s.Successes),
+		Failures:           atomic.LoadInt64(&s.Failures),
+		Aborts:             atomic.LoadInt64(&s.Aborts),
+		Validations:        atomic.LoadInt64(&s.Validations),
+		ValidationFailures: atomic.LoadInt64(&s.ValidationFailures),
+		ExecTime:           time.Duration(atomic.LoadInt64((*int64)(&s.ExecTime))),
+		ValidationTime:     time.Duration(atomic.LoadInt64((*int64)(&s.ValidationTime))),
+		conflicts:          s.conflicts,
+	}
+}
+
+// finish: completes the stats once execution stops. busyTime is the time the workers spent on tasks and incarnation,
+//  if set, gives the last incarnation of each transaction.
+func (s *ExecStats) finish(start time.Time, busyTime time.Duration, incarnation func(txIdx int) int) {
+	s.WallTime = time.Since(start)
+	if s.WallTime > 0 && s.Workers > 0 {
+		s.Utilization = float64(busyTime) / float64(s.WallTime*time.Duration(s.Workers))
+	}
+	if incarnation == nil {
+		return
+	}
+	s.Incarnations = make([]int, s.NumTxns)
+	for i := range s.Incarnations {
+		s.Incarnations[i] = incarnation(i) + 1
+	}
+}
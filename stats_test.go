@@ -0,0 +1,50 @@
+package block_stm
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestExecStats(t *testing.T) {
+	const numTasks = 20
+	exec := makeTestConflictTasks(numTasks)
+	var rw testBaseReadWrite
+
+	// a single worker executes everything in order so there are no conflicts
+	e := NewExecutor(ExecOptions{Workers: 1})
+	for _, execute := range append(testExecutors(e), e.ExecuteSerial) {
+		_, _, stats, err := execute(exec, &rw)
+		require.NoError(t, err)
+		require.Equal(t, numTasks, stats.NumTxns)
+		require.Equal(t, 1, stats.Workers)
+		require.Equal(t, int64(numTasks), stats.Executions)
+		require.Equal(t, int64(numTasks), stats.Successes)
+		require.Equal(t, int64(0), stats.Aborts)
+		for _, inc := range stats.Incarnations {
+			require.Equal(t, 1, inc)
+		}
+		require.GreaterOrEqual(t, stats.ExecTime, numTasks*time.Millisecond)
+		require.GreaterOrEqual(t, stats.WallTime, stats.ExecTime)
+		require.Greater(t, stats.Utilization, 0.5)
+		require.LessOrEqual(t, stats.Utilization, 1.0)
+	}
+
+	e = NewExecutor(ExecOptions{})
+	for _, execute := range testExecutors(e) {
+		_, _, stats, err := execute(exec, &rw)
+		require.NoError(t, err)
+		require.Equal(t, defaultNumWorkers, stats.Workers)
+		require.Equal(t, stats.Executions, stats.Successes+stats.Aborts+stats.Failures)
+		require.GreaterOrEqual(t, stats.Validations, int64(numTasks))
+		require.Greater(t, stats.ValidationTime, time.Duration(0))
+
+		// every execution is of a new incarnation
+		var sumIncarnations int
+		for _, inc := range stats.Incarnations {
+			require.GreaterOrEqual(t, inc, 1)
+			sumIncarnations += inc
+		}
+		require.Equal(t, stats.Executions, int64(sumIncarnations))
+	}
+}
@@ -132,6 +132,14 @@ func (m *taskStatusManager) checkPending(tx int) bool {
 	return false
 }
 
+func (m *taskStatusManager) checkComplete(tx int) bool {
+	x := sort.SearchInts(m.complete, tx)
+	if x < len(m.complete) && m.complete[x] == tx {
+		return true
+	}
+	return false
+}
+
 // getRevalidationRange: this range will be all tasks from tx (inclusive) that are not currently in progress up to the
 //  'all complete' limit
 func (m *taskStatusManager) getRevalidationRange(txFrom int) (ret []int) {
@@ -0,0 +1,178 @@
+package block_stm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TraceCoordinator: the worker of validations done by the ExecuteParallel coordinator rather than a worker
+const TraceCoordinator = -1
+
+type TraceKind int
+
+const (
+	TraceExecute TraceKind = iota
+	TraceValidate
+)
+
+func (k TraceKind) String() string {
+	if k == TraceValidate {
+		return "validate"
+	}
+	return "execute"
+}
+
+type TraceOutcome string
+
+const (
+	TraceSuccess TraceOutcome = "success"
+	TraceAbort   TraceOutcome = "abort"
+	TraceFailure TraceOutcome = "failure"
+	TraceValid   TraceOutcome = "valid"
+	TraceInvalid TraceOutcome = "invalid"
+)
+
+// TraceEvent: one execution or validation of an incarnation
+type TraceEvent struct {
+	Kind    TraceKind
+	Worker  int
+	Version Version
+	Start   time.Time
+	End     time.Time
+	Outcome TraceOutcome
+	// DepIdx is the transaction whose estimate was read, for an aborted execution
+	DepIdx int
+}
+
+// Tracer: records every execution and validation of a block with the worker that did it, to see how incarnations
+//  cascade. Set it as ExecOptions.Tracer and write the result with WriteChromeTrace. Safe for concurrent use.
+type Tracer struct {
+	mu     sync.Mutex
+	events []TraceEvent
+}
+
+func NewTracer() *Tracer {
+	return &Tracer{}
+}
+
+func (t *Tracer) add(ev TraceEvent) {
+	t.mu.Lock()
+	t.events = append(t.events, ev)
+	t.mu.Unlock()
+}
+
+func (t *Tracer) addExecution(res ExecResult) {
+	ev := TraceEvent{Kind: TraceExecute, Worker: res.worker, Version: res.ver, Start: res.execStart,
+		End: res.execStart.Add(res.execTime), Outcome: TraceSuccess, DepIdx: -1}
+	switch {
+	case res.err == errExecAbort:
+		ev.Outcome, ev.DepIdx = TraceAbort, res.depIdx
+	case res.err != nil:
+		ev.Outcome = TraceFailure
+	}
+	t.add(ev)
+}
+
+func (t *Tracer) addValidation(worker int, ver Version, valid bool, start time.Time, d time.Duration) {
+	ev := TraceEvent{Kind: TraceValidate, Worker: worker, Version: ver, Start: start, End: start.Add(d),
+		Outcome: TraceValid, DepIdx: -1}
+	if !valid {
+		ev.Outcome = TraceInvalid
+	}
+	t.add(ev)
+}
+
+// Events: everything recorded so far, ordered by start time
+func (t *Tracer) Events() []TraceEvent {
+	t.mu.Lock()
+	events := append([]TraceEvent(nil), t.events...)
+	t.mu.Unlock()
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Start.Before(events[j].Start)
+	})
+	return events
+}
+
+// Reset: drops everything recorded, for example before the next block
+func (t *Tracer) Reset() {
+	t.mu.Lock()
+	t.events = nil
+	t.mu.Unlock()
+}
+
+type chromeTraceEvent struct {
+	Name  string                 `json:"name"`
+	Cat   string                 `json:"cat,omitempty"`
+	Ph    string                 `json:"ph"`
+	Ts    float64                `json:"ts"`
+	Dur   float64                `json:"dur,omitempty"`
+	Pid   int                    `json:"pid"`
+	Tid   int                    `json:"tid"`
+	Cname string                 `json:"cname,omitempty"`
+	Args  map[string]interface{} `json:"args,omitempty"`
+}
+
+// chromeTraceColors: reserved color names of the trace viewer
+var chromeTraceColors = map[TraceOutcome]string{
+	TraceSuccess: "good",
+	TraceAbort:   "terrible",
+	TraceFailure: "bad",
+	TraceValid:   "olive",
+	TraceInvalid: "yellow",
+}
+
+// WriteChromeTrace: writes the events in the Chrome trace-event JSON format, viewable in Perfetto or
+//  chrome://tracing. Every worker is a thread, with the coordinator as thread 0, and timestamps are relative to the
+//  first event.
+func (t *Tracer) WriteChromeTrace(w io.Writer) error {
+	events := t.Events()
+
+	var chromeEvents []chromeTraceEvent
+	threads := make(map[int]bool)
+	var start time.Time
+	if len(events) > 0 {
+		start = events[0].Start
+	}
+	for _, ev := range events {
+		args := map[string]interface{}{"tx": ev.Version.TxnIndex, "incarnation": ev.Version.Incarnation,
+			"outcome": ev.Outcome}
+		if ev.DepIdx >= 0 {
+			args["dependency"] = ev.DepIdx
+		}
+		chromeEvents = append(chromeEvents, chromeTraceEvent{
+			Name:  fmt.Sprintf("%v %v.%v", ev.Kind, ev.Version.TxnIndex, ev.Version.Incarnation),
+			Cat:   ev.Kind.String(),
+			Ph:    "X",
+			Ts:    float64(ev.Start.Sub(start).Nanoseconds()) / 1e3,
+			Dur:   float64(ev.End.Sub(ev.Start).Nanoseconds()) / 1e3,
+			Tid:   ev.Worker + 1,
+			Cname: chromeTraceColors[ev.Outcome],
+			Args:  args,
+		})
+		threads[ev.Worker] = true
+	}
+
+	// metadata naming the threads, in a stable order
+	var workers []int
+	for worker := range threads {
+		workers = append(workers, worker)
+	}
+	sort.Ints(workers)
+	for _, worker := range workers {
+		name := fmt.Sprintf("worker %v", worker)
+		if worker == TraceCoordinator {
+			name = "coordinator"
+		}
+		chromeEvents = append(chromeEvents, chromeTraceEvent{Name: "thread_name", Ph: "M", Tid: worker + 1,
+			Args: map[string]interface{}{"name": name}})
+	}
+
+	return json.NewEncoder(w).Encode(struct {
+		TraceEvents     []chromeTraceEvent `json:"traceEvents"`
+		DisplayTimeUnit string             `json:"displayTimeUnit"`
+	}{chromeEvents, "ms"})
+}
@@ -0,0 +1,89 @@
+package block_stm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestTracer(t *testing.T) {
+	exec := makeTestConflictTasks(30)
+	var rw testBaseReadWrite
+
+	tracer := NewTracer()
+	e := NewExecutor(ExecOptions{Workers: 4, Tracer: tracer})
+	for i, execute := range testExecutors(e) {
+		tracer.Reset()
+		_, _, stats, err := execute(exec, &rw)
+		require.NoError(t, err)
+
+		var cntExec, cntAbort, cntValidate, cntInvalid int64
+		for _, ev := range tracer.Events() {
+			require.False(t, ev.End.Before(ev.Start))
+			switch ev.Kind {
+			case TraceExecute:
+				cntExec++
+				require.GreaterOrEqual(t, ev.Worker, 0)
+				require.Less(t, ev.Worker, 4)
+				if ev.Outcome == TraceAbort {
+					cntAbort++
+					require.Less(t, ev.DepIdx, ev.Version.TxnIndex)
+				} else {
+					require.Equal(t, TraceSuccess, ev.Outcome)
+				}
+			case TraceValidate:
+				cntValidate++
+				if i == 0 {
+					// validation is done by the coordinator
+					require.Equal(t, TraceCoordinator, ev.Worker)
+				} else {
+					require.GreaterOrEqual(t, ev.Worker, 0)
+				}
+				if ev.Outcome == TraceInvalid {
+					cntInvalid++
+				}
+			}
+		}
+		require.Equal(t, stats.Executions, cntExec)
+		require.Equal(t, stats.Aborts, cntAbort)
+		require.Equal(t, stats.Validations, cntValidate)
+		require.Equal(t, stats.ValidationFailures, cntInvalid)
+
+		var buf bytes.Buffer
+		require.NoError(t, tracer.WriteChromeTrace(&buf))
+		var trace struct {
+			TraceEvents []struct {
+				Name string                 `json:"name"`
+				Ph   string                 `json:"ph"`
+				Ts   float64                `json:"ts"`
+				Tid  int                    `json:"tid"`
+				Args map[string]interface{} `json:"args"`
+			} `json:"traceEvents"`
+		}
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &trace))
+		var cntComplete int64
+		threads := make(map[int]string)
+		for _, ev := range trace.TraceEvents {
+			switch ev.Ph {
+			case "X":
+				cntComplete++
+				require.GreaterOrEqual(t, ev.Ts, 0.0)
+				require.Contains(t, ev.Args, "tx")
+				require.Contains(t, ev.Args, "outcome")
+			case "M":
+				threads[ev.Tid] = ev.Args["name"].(string)
+			}
+		}
+		require.Equal(t, cntExec+cntValidate, cntComplete)
+		if i == 0 {
+			require.Equal(t, "coordinator", threads[0])
+		}
+		for tid, name := range threads {
+			if tid > 0 {
+				require.Equal(t, fmt.Sprintf("worker %v", tid-1), name)
+			}
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package block_stm
 
-import "encoding/base64"
+import (
+	"bytes"
+	"sync"
+)
 
 const (
 	ReadKindMap     = 0
@@ -29,28 +32,70 @@ func (txo TxnOutput) hasNewWrite(cmpSet []WriteDescriptor) bool {
 	} else if len(cmpSet) == 0 || len(txo) > len(cmpSet) {
 		return true
 	}
-	cmpMap := map[string]bool{base64.StdEncoding.EncodeToString(cmpSet[0].Path): true}
+	cmpMap := map[string]bool{string(cmpSet[0].Path): true}
 	for i := 1; i < len(cmpSet); i++ {
-		cmpMap[base64.StdEncoding.EncodeToString(cmpSet[i].Path)] = true
+		cmpMap[string(cmpSet[i].Path)] = true
+	}
+	for _, v := range txo {
+		if !cmpMap[string(v.Path)] {
+			return true
+		}
 	}
+	return false
+}
+
+func (txo TxnOutput) hasPath(path []byte) bool {
 	for _, v := range txo {
-		if !cmpMap[base64.StdEncoding.EncodeToString(v.Path)] {
+		if bytes.Equal(v.Path, path) {
 			return true
 		}
 	}
 	return false
 }
 
+// pathsNotIn: returns the writes in the current set to paths that the input does not write
+func (txo TxnOutput) pathsNotIn(cmpSet []WriteDescriptor) (ret []WriteDescriptor) {
+	cmpMap := make(map[string]bool, len(cmpSet))
+	for _, v := range cmpSet {
+		cmpMap[string(v.Path)] = true
+	}
+	for _, v := range txo {
+		if !cmpMap[string(v.Path)] {
+			ret = append(ret, v)
+		}
+	}
+	return
+}
+
+// StateDiff: the final write to each path of a block, ordered by path
+type StateDiff []WriteDescriptor
+
+// Apply: writes the diff to storage in path order
+func (sd StateDiff) Apply(rw BaseReadWrite) error {
+	for _, v := range sd {
+		if err := rw.Write(v.Path, v.Val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type TxnInputOutput struct {
+	rw      sync.RWMutex
 	inputs  []TxnInput
 	outputs []TxnOutput
+	errs    []error
 }
 
 func (io *TxnInputOutput) readSet(txnIdx int) []ReadDescriptor {
+	io.rw.RLock()
+	defer io.rw.RUnlock()
 	return io.inputs[txnIdx]
 }
 
 func (io *TxnInputOutput) writeSet(txnIdx int) []WriteDescriptor {
+	io.rw.RLock()
+	defer io.rw.RUnlock()
 	return io.outputs[txnIdx]
 }
 
@@ -58,13 +103,31 @@ func MakeTxnInputOutput(numTx int) *TxnInputOutput {
 	return &TxnInputOutput{
 		inputs:  make([]TxnInput, numTx),
 		outputs: make([]TxnOutput, numTx),
+		errs:    make([]error, numTx),
 	}
 }
 
+// Err: the error the last incarnation of the transaction failed with, if it was recorded with ErrorPolicyRecordFailure
+func (io *TxnInputOutput) Err(txnIdx int) error {
+	io.rw.RLock()
+	defer io.rw.RUnlock()
+	return io.errs[txnIdx]
+}
+
 func (io *TxnInputOutput) recordRead(txId int, input []ReadDescriptor) {
+	io.rw.Lock()
+	defer io.rw.Unlock()
 	io.inputs[txId] = input
 }
 
 func (io *TxnInputOutput) recordWrite(txId int, output []WriteDescriptor) {
+	io.rw.Lock()
+	defer io.rw.Unlock()
 	io.outputs[txId] = output
 }
+
+func (io *TxnInputOutput) recordErr(txId int, err error) {
+	io.rw.Lock()
+	defer io.rw.Unlock()
+	io.errs[txId] = err
+}
@@ -0,0 +1,177 @@
+package block_stm
+
+import (
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// maxVersionLevel: with every node on the next level with probability 1/4, enough for millions of versions
+const maxVersionLevel = 12
+
+// cellRef and nodeRef: pointers that are read without a lock so are always loaded and stored atomically
+type cellRef struct {
+	p unsafe.Pointer
+}
+
+func (r *cellRef) load() *WriteCell {
+	return (*WriteCell)(atomic.LoadPointer(&r.p))
+}
+
+func (r *cellRef) store(c *WriteCell) {
+	atomic.StorePointer(&r.p, unsafe.Pointer(c))
+}
+
+type nodeRef struct {
+	p unsafe.Pointer
+}
+
+func (r *nodeRef) load() *versionNode {
+	return (*versionNode)(atomic.LoadPointer(&r.p))
+}
+
+func (r *nodeRef) store(n *versionNode) {
+	atomic.StorePointer(&r.p, unsafe.Pointer(n))
+}
+
+type versionNode struct {
+	txIdx int
+	// cell is replaced rather than updated so readers always see a consistent write
+	cell cellRef
+	// next is inline so following a link is one pointer, only the first level of them are used
+	level int
+	next  [maxVersionLevel]nodeRef
+}
+
+// TxnIndexCells: the writes to one location as a skiplist ordered by transaction index. Writers are serialized by mu
+//  but readers don't lock - nodes are only published once complete and a removed node keeps its links, so a reader
+//  that is on it still finds its way to the rest of the list. A read concurrent with a write may see the list as it
+//  was before the write, which validation catches the same as any other stale read.
+type TxnIndexCells struct {
+	mu   sync.Mutex
+	head versionNode
+	// level is the number of levels in use, rnd is the state of the level generator. Both only change under mu.
+	level int32
+	rnd   uint32
+}
+
+// pools of everything allocated for the versions of a block, filled by MVHashMap.Reset. Nodes and cells can only be
+//  put back once nothing is reading the map, not as soon as they are removed or replaced.
+var (
+	txnIndexCellsPool = sync.Pool{New: func() interface{} {
+		return &TxnIndexCells{head: versionNode{txIdx: -1, level: maxVersionLevel}, level: 1, rnd: 1}
+	}}
+	versionNodePool = sync.Pool{New: func() interface{} { return &versionNode{} }}
+	writeCellPool   = sync.Pool{New: func() interface{} { return &WriteCell{} }}
+)
+
+func makeTxnIndexCells() *TxnIndexCells {
+	return txnIndexCellsPool.Get().(*TxnIndexCells)
+}
+
+func makeWriteCell(flag uint, incarnation int, data []byte) *WriteCell {
+	c := writeCellPool.Get().(*WriteCell)
+	c.flag, c.incarnation, c.data = flag, incarnation, data
+	return c
+}
+
+// release: puts the cells, every node in them and their current writes back in the pools. Nothing can be reading
+//  them.
+func (cells *TxnIndexCells) release() {
+	for n := cells.head.next[0].load(); n != nil; {
+		next := n.next[0].load()
+		c := n.cell.load()
+		*c = WriteCell{}
+		writeCellPool.Put(c)
+		*n = versionNode{}
+		versionNodePool.Put(n)
+		n = next
+	}
+	cells.head = versionNode{txIdx: -1, level: maxVersionLevel}
+	cells.level, cells.rnd = 1, 1
+	txnIndexCellsPool.Put(cells)
+}
+
+// randomLevel: xorshift, must be called with mu held
+func (cells *TxnIndexCells) randomLevel() int {
+	level := 1
+	for level < maxVersionLevel {
+		cells.rnd ^= cells.rnd << 13
+		cells.rnd ^= cells.rnd >> 17
+		cells.rnd ^= cells.rnd << 5
+		if cells.rnd&3 != 0 {
+			break
+		}
+		level++
+	}
+	return level
+}
+
+// floor: the node with the highest transaction index at or below txIdx, nil if there is none. Doesn't lock.
+func (cells *TxnIndexCells) floor(txIdx int) *versionNode {
+	x := &cells.head
+	for l := int(atomic.LoadInt32(&cells.level)) - 1; l >= 0; l-- {
+		for n := x.next[l].load(); n != nil && n.txIdx <= txIdx; n = x.next[l].load() {
+			x = n
+		}
+	}
+	if x == &cells.head {
+		return nil
+	}
+	return x
+}
+
+// find: the last node before txIdx on every level, and the node for txIdx if there is one. Must be called with mu held.
+func (cells *TxnIndexCells) find(txIdx int, prev *[maxVersionLevel]*versionNode) *versionNode {
+	x := &cells.head
+	for l := maxVersionLevel - 1; l >= int(cells.level); l-- {
+		prev[l] = x
+	}
+	for l := int(cells.level) - 1; l >= 0; l-- {
+		for n := x.next[l].load(); n != nil && n.txIdx < txIdx; n = x.next[l].load() {
+			x = n
+		}
+		prev[l] = x
+	}
+	if n := x.next[0].load(); n != nil && n.txIdx == txIdx {
+		return n
+	}
+	return nil
+}
+
+// get: the write of txIdx. Must be called with mu held.
+func (cells *TxnIndexCells) get(txIdx int) *versionNode {
+	var prev [maxVersionLevel]*versionNode
+	return cells.find(txIdx, &prev)
+}
+
+// insert: adds a node for txIdx after the nodes found for it by find. Must be called with mu held.
+func (cells *TxnIndexCells) insert(txIdx int, c *WriteCell, prev *[maxVersionLevel]*versionNode) {
+	level := cells.randomLevel()
+	n := versionNodePool.Get().(*versionNode)
+	n.txIdx, n.level = txIdx, level
+	n.cell.store(c)
+	// link the node before publishing it, from the bottom up so it is in the base list before any index to it
+	for l := 0; l < level; l++ {
+		n.next[l].store(prev[l].next[l].load())
+	}
+	if int32(level) > cells.level {
+		atomic.StoreInt32(&cells.level, int32(level))
+	}
+	for l := 0; l < level; l++ {
+		prev[l].next[l].store(n)
+	}
+}
+
+// remove: drops the write of txIdx if there is one. Must be called with mu held.
+func (cells *TxnIndexCells) remove(txIdx int) {
+	var prev [maxVersionLevel]*versionNode
+	n := cells.find(txIdx, &prev)
+	if n == nil {
+		return
+	}
+	// unlink from the top down so the node is never in an index but missing from the base list
+	for l := n.level - 1; l >= 0; l-- {
+		prev[l].next[l].store(n.next[l].load())
+	}
+}
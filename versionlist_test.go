@@ -0,0 +1,115 @@
+package block_stm
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"math/rand"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// floorOf: the reference floor of txIdx in the written tx indexes
+func floorOf(written map[int]int, txIdx int) (int, bool) {
+	var keys []int
+	for k := range written {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	i := sort.SearchInts(keys, txIdx+1)
+	if i == 0 {
+		return 0, false
+	}
+	return keys[i-1], true
+}
+
+func TestTxnIndexCells(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	cells := makeTxnIndexCells()
+	require.Nil(t, cells.floor(100))
+
+	written := make(map[int]int)
+	for i := 0; i < 5000; i++ {
+		txIdx := r.Intn(500)
+		cells.mu.Lock()
+		if r.Intn(3) == 0 {
+			cells.remove(txIdx)
+			delete(written, txIdx)
+		} else {
+			var prev [maxVersionLevel]*versionNode
+			if n := cells.find(txIdx, &prev); n != nil {
+				n.cell.store(&WriteCell{incarnation: i})
+			} else {
+				cells.insert(txIdx, &WriteCell{incarnation: i}, &prev)
+			}
+			written[txIdx] = i
+		}
+		cells.mu.Unlock()
+
+		target := r.Intn(520) - 10
+		n := cells.floor(target)
+		expected, ok := floorOf(written, target)
+		if !ok {
+			require.Nil(t, n, "floor of %v", target)
+			continue
+		}
+		require.NotNil(t, n, "floor of %v", target)
+		require.Equal(t, expected, n.txIdx)
+		require.Equal(t, written[expected], n.cell.load().incarnation)
+	}
+}
+
+func TestTxnIndexCellsConcurrentReads(t *testing.T) {
+	// even tx indexes are always present so a reader has to find one at or just below its target, whatever the writer
+	//  is doing to the odd ones
+	mvh := MakeMVHashMap()
+	k := []byte("/foo/b")
+	for i := 0; i < 200; i += 2 {
+		require.NoError(t, mvh.Write(k, Version{i, 0}, valueFor(i, 0)))
+	}
+
+	done := make(chan struct{})
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		r := rand.New(rand.NewSource(1))
+		for inc := 0; ; inc++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			txIdx := 2*r.Intn(100) + 1
+			if r.Intn(2) == 0 {
+				_ = mvh.Delete(k, txIdx)
+			} else {
+				_ = mvh.Write(k, Version{txIdx, inc}, valueFor(txIdx, inc))
+				_ = mvh.MarkEstimate(k, txIdx)
+			}
+		}
+	}()
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(int64(w)))
+			for i := 0; i < 20000; i++ {
+				txIdx := 1 + r.Intn(200)
+				res := mvh.Read(k, txIdx)
+				// require can't stop the test from a reader, so it stops at the first failure instead
+				if !assert.GreaterOrEqual(t, res.depIdx, txIdx-2) || !assert.Less(t, res.depIdx, txIdx) {
+					return
+				}
+				if res.depIdx%2 == 0 {
+					if !assert.Equal(t, mvReadResultDone, res.status()) || !assert.Equal(t, valueFor(res.depIdx, 0), res.value) {
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(done)
+	<-writerDone
+}